@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidBatch is returned for a batch operation that is unknown or
+// refers to a result that does not exist
+var ErrInvalidBatch = errors.New("Invalid batch operation")
+
+// Transact runs fn with a Permissionist whose changes are kept only if fn
+// returns nil. fn must make every change through tx.
+func (permissions *Permissionist) Transact(fn func(tx *Permissionist) error) error {
+	return permissions.Store.Transact(func(store Store) error {
+		return fn(&Permissionist{store})
+	})
+}
+
+// BatchOperation is one step of ApplyBatch. Op names the Permissionist
+// method to call in snake case, such as create_role or
+// assign_permission_to_role, and the other fields are its arguments.
+// ParentID is the parent role or group, and Tuple the relation tuple for
+// write_relation_tuple and delete_relation_tuple. An operation that creates
+// a record may set Ref; later operations can then pass "$" followed by that
+// ref for any id argument, so a batch can create an app and fill it in.
+type BatchOperation struct {
+	Op           string         `json:"op"`
+	Ref          string         `json:"ref,omitempty"`
+	Name         string         `json:"name,omitempty"`
+	AppID        string         `json:"app_id,omitempty"`
+	RoleID       string         `json:"role_id,omitempty"`
+	ParentID     string         `json:"parent_id,omitempty"`
+	PermissionID string         `json:"permission_id,omitempty"`
+	GroupID      string         `json:"group_id,omitempty"`
+	EntityID     string         `json:"entity_id,omitempty"`
+	Condition    string         `json:"condition,omitempty"`
+	Tuple        *RelationTuple `json:"tuple,omitempty"`
+	Resource
+	Validity
+}
+
+// BatchResult is the outcome of one BatchOperation. ID is set for
+// operations that create a record.
+type BatchResult struct {
+	Op  string `json:"op"`
+	Ref string `json:"ref,omitempty"`
+	ID  string `json:"id,omitempty"`
+}
+
+// BatchError reports which operation made a batch fail
+type BatchError struct {
+	Index int
+	Op    string
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("Operation %d (%s) failed: %v", e.Index, e.Op, e.Err)
+}
+
+// Cause returns the error the operation failed with
+func (e *BatchError) Cause() error {
+	return errors.Cause(e.Err)
+}
+
+// batchOperations maps each op to the call it makes, returning the id of
+// the record it creates, if any
+var batchOperations = map[string]func(tx *Permissionist, op BatchOperation) (string, error){
+	"create_app": func(tx *Permissionist, op BatchOperation) (string, error) {
+		app, err := tx.CreateApp(op.Name)
+		return app.ID, err
+	},
+	"rename_app": func(tx *Permissionist, op BatchOperation) (string, error) {
+		_, err := tx.RenameApp(op.AppID, op.Name)
+		return "", err
+	},
+	"remove_app": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.RemoveApp(op.AppID)
+	},
+	"create_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		role, err := tx.CreateRole(op.Name, op.AppID)
+		return role.ID, err
+	},
+	"rename_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		_, err := tx.RenameRole(op.RoleID, op.Name)
+		return "", err
+	},
+	"remove_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.RemoveRole(op.RoleID)
+	},
+	"create_permission": func(tx *Permissionist, op BatchOperation) (string, error) {
+		perm, err := tx.CreatePermission(op.Name, op.AppID)
+		return perm.ID, err
+	},
+	"rename_permission": func(tx *Permissionist, op BatchOperation) (string, error) {
+		_, err := tx.RenamePermission(op.PermissionID, op.Name)
+		return "", err
+	},
+	"remove_permission": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.RemovePermission(op.PermissionID)
+	},
+	"assign_permission_to_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AssignPermissionToRole(op.RoleID, op.PermissionID)
+	},
+	"unassign_permission_from_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.UnassignPermissionFromRole(op.RoleID, op.PermissionID)
+	},
+	"deny_permission_to_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.DenyPermissionToRole(op.RoleID, op.PermissionID)
+	},
+	"remove_role_denial": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.RemoveRoleDenial(op.RoleID, op.PermissionID)
+	},
+	"assign_parent_to_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AssignParentToRole(op.RoleID, op.ParentID)
+	},
+	"unassign_parent_from_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.UnassignParentFromRole(op.RoleID, op.ParentID)
+	},
+	"assign_role_to_entity": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AssignRoleToEntity(op.EntityID, op.RoleID, op.Resource)
+	},
+	"unassign_role_from_entity": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.UnassignRoleFromEntity(op.EntityID, op.RoleID, op.Resource)
+	},
+	"deny_permission_to_entity": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.DenyPermissionToEntity(op.EntityID, op.PermissionID)
+	},
+	"remove_entity_denial": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.RemoveEntityDenial(op.EntityID, op.PermissionID)
+	},
+	"assign_temporary_role_to_entity": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AssignTemporaryRoleToEntity(op.EntityID, op.RoleID, op.Resource, op.Validity)
+	},
+	"assign_temporary_permission_to_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AssignTemporaryPermissionToRole(op.RoleID, op.PermissionID, op.Validity)
+	},
+	"assign_conditional_permission_to_role": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AssignConditionalPermissionToRole(op.RoleID, op.PermissionID, op.Condition)
+	},
+	"create_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		group, err := tx.CreateGroup(op.Name, op.AppID)
+		return group.ID, err
+	},
+	"remove_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.RemoveGroup(op.GroupID)
+	},
+	"add_entity_to_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AddEntityToGroup(op.GroupID, op.EntityID)
+	},
+	"remove_entity_from_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.RemoveEntityFromGroup(op.GroupID, op.EntityID)
+	},
+	"nest_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.NestGroup(op.GroupID, op.ParentID)
+	},
+	"unnest_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.UnnestGroup(op.GroupID, op.ParentID)
+	},
+	"assign_role_to_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.AssignRoleToGroup(op.GroupID, op.RoleID)
+	},
+	"unassign_role_from_group": func(tx *Permissionist, op BatchOperation) (string, error) {
+		return "", tx.UnassignRoleFromGroup(op.GroupID, op.RoleID)
+	},
+	"write_relation_tuple": func(tx *Permissionist, op BatchOperation) (string, error) {
+		if op.Tuple == nil {
+			return "", errors.Wrap(ErrMissingField, "Missing tuple")
+		}
+		return "", tx.WriteRelationTuple(*op.Tuple)
+	},
+	"delete_relation_tuple": func(tx *Permissionist, op BatchOperation) (string, error) {
+		if op.Tuple == nil {
+			return "", errors.Wrap(ErrMissingField, "Missing tuple")
+		}
+		return "", tx.DeleteRelationTuple(*op.Tuple)
+	},
+}
+
+// ApplyBatch runs operations in order in a single transaction. Either
+// every operation succeeds or none has any effect, and the error is a
+// BatchError naming the one that failed.
+func (permissions *Permissionist) ApplyBatch(operations []BatchOperation) ([]BatchResult, error) {
+	var results []BatchResult
+	err := permissions.Transact(func(tx *Permissionist) error {
+		results = nil
+		refs := map[string]string{}
+		for i, op := range operations {
+			id, err := applyBatchOperation(tx, op, refs)
+			if err != nil {
+				return &BatchError{Index: i, Op: op.Op, Err: err}
+			}
+			if op.Ref != "" && id != "" {
+				refs[op.Ref] = id
+			}
+			results = append(results, BatchResult{Op: op.Op, Ref: op.Ref, ID: id})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// applyBatchOperation resolves op's refs and runs it
+func applyBatchOperation(tx *Permissionist, op BatchOperation, refs map[string]string) (string, error) {
+	run, ok := batchOperations[op.Op]
+	if !ok {
+		return "", errors.Wrapf(ErrInvalidBatch, "Unknown op %q", op.Op)
+	}
+	for _, field := range []*string{&op.AppID, &op.RoleID, &op.ParentID, &op.PermissionID, &op.GroupID} {
+		if !strings.HasPrefix(*field, "$") {
+			continue
+		}
+		id, ok := refs[strings.TrimPrefix(*field, "$")]
+		if !ok {
+			return "", errors.Wrapf(ErrInvalidBatch, "Unknown ref %q", *field)
+		}
+		*field = id
+	}
+	return run(tx, op)
+}
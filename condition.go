@@ -0,0 +1,394 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCondition is returned for a condition that does not parse
+var ErrInvalidCondition = errors.New("Invalid condition")
+
+// Context holds the attributes a condition is evaluated against, such as
+// {"region": "eu", "caller": {"region": "eu"}}. It is usually decoded from
+// JSON.
+type Context map[string]interface{}
+
+// A condition is a boolean expression over a Context, for example
+//
+//	region == caller.region && (priority > 2 || "support" in caller.teams)
+//
+// Operands are dotted paths into the context, "strings" or 'strings',
+// numbers, true and false. Operators are == != < <= > >= in && || ! and
+// parentheses. in tests membership of a list. A comparison involving a
+// path missing from the context is unknown, whichever operator it uses.
+// ! keeps unknown unknown, && and || are false or true only if the known
+// operands decide it, and a condition that is unknown does not hold, so a
+// condition never holds for lack of information.
+type condition interface {
+	eval(context Context) interface{}
+}
+
+// missing is the value of a path not present in the context, and of any
+// expression it leaves unknown
+type missing struct{}
+
+type literal struct{ value interface{} }
+
+type path []string
+
+type not struct{ operand condition }
+
+type binary struct {
+	op          string
+	left, right condition
+}
+
+func (l literal) eval(context Context) interface{} { return l.value }
+
+func (p path) eval(context Context) interface{} {
+	var value interface{} = map[string]interface{}(context)
+	for _, key := range p {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			if c, isContext := value.(Context); isContext {
+				object, ok = map[string]interface{}(c), true
+			}
+		}
+		if !ok {
+			return missing{}
+		}
+		if value, ok = object[key]; !ok {
+			return missing{}
+		}
+	}
+	return normalize(value)
+}
+
+func (n not) eval(context Context) interface{} {
+	value, ok := n.operand.eval(context).(bool)
+	if !ok {
+		return missing{}
+	}
+	return !value
+}
+
+func (b binary) eval(context Context) interface{} {
+	switch b.op {
+	case "&&", "||":
+		// the operator's value if either operand has it, unknown unless
+		// both operands are known
+		decisive := b.op == "||"
+		left, leftKnown := b.left.eval(context).(bool)
+		right, rightKnown := b.right.eval(context).(bool)
+		if (leftKnown && left == decisive) || (rightKnown && right == decisive) {
+			return decisive
+		}
+		if !leftKnown || !rightKnown {
+			return missing{}
+		}
+		return !decisive
+	}
+	left, right := b.left.eval(context), b.right.eval(context)
+	if _, ok := left.(missing); ok {
+		return missing{}
+	}
+	if _, ok := right.(missing); ok {
+		return missing{}
+	}
+	switch b.op {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	case "in":
+		list, ok := right.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			if reflect.DeepEqual(left, normalize(item)) {
+				return true
+			}
+		}
+		return false
+	}
+	return compare(b.op, left, right)
+}
+
+// holds checks if c evaluates to true against context
+func holds(c condition, context Context) bool {
+	value, ok := c.eval(context).(bool)
+	return ok && value
+}
+
+// compare orders two numbers or two strings
+func compare(op string, left interface{}, right interface{}) bool {
+	var sign int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			sign = -1
+		case l > r:
+			sign = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		sign = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return sign < 0
+	case "<=":
+		return sign <= 0
+	case ">":
+		return sign > 0
+	case ">=":
+		return sign >= 0
+	}
+	return false
+}
+
+// normalize converts Go numbers to float64, as decoding JSON does, so
+// contexts built in Go compare like decoded ones
+func normalize(value interface{}) interface{} {
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32:
+		return v.Float()
+	case reflect.Slice:
+		if _, ok := value.([]interface{}); ok {
+			return value
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = v.Index(i).Interface()
+		}
+		return list
+	}
+	return value
+}
+
+// AssignConditionalPermissionToRole assigns permission to role where the
+// condition expression holds. Only context-aware checks such as
+// EntityIsAllowedInContext count the grant, and only when condition holds
+// in the context they are given.
+func (permissions *Permissionist) AssignConditionalPermissionToRole(roleID string, permissionID string, expression string) error {
+	if expression == "" {
+		return errors.Wrap(ErrInvalidCondition, "Could not assign permission to role")
+	}
+	return permissions.grantPermissionToRole(roleID, permissionID, expression, Validity{})
+}
+
+// parseCondition parses a condition expression
+func parseCondition(expression string) (condition, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.Wrapf(ErrInvalidCondition, "Unexpected %q", p.tokens[p.pos].text)
+	}
+	return c, nil
+}
+
+// evalCondition checks if expression holds against context. The empty
+// expression always holds.
+func evalCondition(expression string, context Context) bool {
+	if expression == "" {
+		return true
+	}
+	c, err := parseCondition(expression)
+	if err != nil {
+		return false
+	}
+	return holds(c, context)
+}
+
+type tokenKind int
+
+const (
+	tokenOperator tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var conditionOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j == len(runes) {
+				return nil, errors.Wrap(ErrInvalidCondition, "Unterminated string")
+			}
+			tokens = append(tokens, token{tokenString, string(runes[i+1 : j])})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[i:j])})
+			i = j
+		default:
+			matched := false
+			for _, op := range conditionOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, token{tokenOperator, op})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.Wrapf(ErrInvalidCondition, "Unexpected %q", string(r))
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type conditionParser struct {
+	tokens []token
+	pos    int
+}
+
+// accept consumes the next token if it is the operator or keyword text
+func (p *conditionParser) accept(text string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind != tokenString && p.tokens[p.pos].text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) or() (condition, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right condition
+		right, err = p.and()
+		left = binary{"||", left, right}
+	}
+	return left, err
+}
+
+func (p *conditionParser) and() (condition, error) {
+	left, err := p.not()
+	for err == nil && p.accept("&&") {
+		var right condition
+		right, err = p.not()
+		left = binary{"&&", left, right}
+	}
+	return left, err
+}
+
+func (p *conditionParser) not() (condition, error) {
+	if p.accept("!") {
+		operand, err := p.not()
+		return not{operand}, err
+	}
+	return p.comparison()
+}
+
+func (p *conditionParser) comparison() (condition, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.accept(op) {
+			right, err := p.operand()
+			return binary{op, left, right}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) operand() (condition, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.Wrap(ErrInvalidCondition, "Unexpected end of condition")
+	}
+	if p.accept("(") {
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errors.Wrap(ErrInvalidCondition, "Missing )")
+		}
+		return c, nil
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case tokenString:
+		return literal{t.text}, nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidCondition, "Bad number %q", t.text)
+		}
+		return literal{number}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "in":
+			return nil, errors.Wrap(ErrInvalidCondition, "Unexpected in")
+		}
+		segments := strings.Split(t.text, ".")
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, errors.Wrapf(ErrInvalidCondition, "Bad path %q", t.text)
+			}
+		}
+		return path(segments), nil
+	}
+	return nil, errors.Wrapf(ErrInvalidCondition, "Unexpected %q", t.text)
+}
@@ -3,21 +3,50 @@ package main
 import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/spf13/viper"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 )
 
 func InitConfig() *viper.Viper {
 	config := viper.New()
 	config.SetConfigFile(os.Getenv("CONFIG"))
+	config.SetDefault("store", "postgres")
+	config.SetDefault("sweep_interval", "1m")
 	err := config.ReadInConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
+	// GetDuration is 0 for a value it cannot parse, and StartSweeper needs
+	// a positive interval
+	if config.GetDuration("sweep_interval") <= 0 {
+		log.Fatalf("sweep_interval must be a positive duration such as \"1m\", not %q", config.GetString("sweep_interval"))
+	}
 	return config
 }
 
+// InitStore connects to and migrates the backend named by the "store" key:
+// "postgres" (using "database"), "sqlite" (using "sqlite") or "memory"
+func InitStore(config *viper.Viper) Store {
+	switch config.GetString("store") {
+	case "postgres":
+		db := InitDb(config.GetString("database"))
+		Migrate(db, "migrate.sql")
+		return NewSQLStore(db)
+	case "sqlite":
+		db := InitSQLite(config.GetString("sqlite"))
+		Migrate(db, "migrate_sqlite.sql")
+		return NewSQLStore(db)
+	case "memory":
+		return NewMemoryStore()
+	}
+	log.Fatalf("Unknown store %q", config.GetString("store"))
+	return nil
+}
+
 func InitDb(database string) *sqlx.DB {
 	db, err := sqlx.Connect("postgres", database)
 	if err != nil {
@@ -25,3 +54,33 @@ func InitDb(database string) *sqlx.DB {
 	}
 	return db
 }
+
+// InitSQLite opens a sqlite database file with foreign keys enforced, which
+// the cascading deletes rely on
+func InitSQLite(path string) *sqlx.DB {
+	if strings.Contains(path, "?") {
+		path += "&_foreign_keys=1"
+	} else {
+		path += "?_foreign_keys=1"
+	}
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// sqlite serializes writers anyway; a single connection avoids
+	// SQLITE_BUSY and keeps :memory: databases alive
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+// Migrate runs a schema file against db
+func Migrate(db *sqlx.DB, schemaFile string) {
+	schemas, err := ioutil.ReadFile(schemaFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(string(schemas))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
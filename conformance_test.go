@@ -0,0 +1,1964 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Rows in seed.sql
+const (
+	seedAppID          = "697d78cb-b56d-41ad-a7a3-e2e08ebb09fb"
+	seedAdminRoleID    = "c51003fc-2ae4-4296-9d5e-325c76a40316"
+	seedCustomerRoleID = "c1688c91-b818-4917-a20e-b95a2006c07f"
+	seedReadID         = "5bee1c60-43e4-460e-80ae-b7c3b8774033"
+	seedWriteID        = "73017965-b16c-4c6e-9ec1-1e1272594648"
+	seedDeleteID       = "28a212cc-51eb-4e17-95e1-2baa65e55b16"
+	seedAdminEntity    = "809e5e2f-0555-4d81-8f91-d6d8f0d4ea79"
+	seedCustomerEntity = "07df4a77-6243-41cd-a421-90c524ef2203"
+	missingID          = "00000000-0000-0000-0000-000000000000"
+)
+
+// testBackends returns a factory per backend under test. Each call yields an
+// empty, migrated store. Postgres is only included when CONFIG is set.
+func testBackends() map[string]func() Store {
+	backends := map[string]func() Store{
+		"memory": func() Store {
+			return NewMemoryStore()
+		},
+		"sqlite": func() Store {
+			db := InitSQLite(":memory:")
+			Migrate(db, "migrate_sqlite.sql")
+			return NewSQLStore(db)
+		},
+	}
+	if os.Getenv("CONFIG") != "" {
+		backends["postgres"] = func() Store {
+			config := testConfig()
+			db := testDb(config.GetString("database"))
+			testCleanup(db)
+			Migrate(db, "migrate.sql")
+			return NewSQLStore(db)
+		}
+	}
+	return backends
+}
+
+// testSeed inserts the rows in seed.sql through the Store interface
+func testSeed(store Store) {
+	err := store.InsertApp(App{seedAppID, "TacoApp"})
+	if err == nil {
+		_, err = store.InsertRoles([]Role{
+			{seedAdminRoleID, "admin", seedAppID},
+			{seedCustomerRoleID, "customer", seedAppID},
+		}, InsertStrict)
+	}
+	if err == nil {
+		_, err = store.InsertPermissions([]Permission{
+			{seedReadID, "read", seedAppID},
+			{seedWriteID, "write", seedAppID},
+			{seedDeleteID, "delete", seedAppID},
+		}, InsertStrict)
+	}
+	for _, rp := range []RolePermission{
+		{"87c5d2bd-13d7-447f-ba63-84eeaa0ac928", seedAdminRoleID, seedReadID, false, "", Validity{}},
+		{"d87fea35-4344-4930-9a59-be976df0266a", seedAdminRoleID, seedWriteID, false, "", Validity{}},
+		{"3d144533-fafd-4050-9dc5-7ab3e479cd73", seedAdminRoleID, seedDeleteID, false, "", Validity{}},
+		{"5b4aec52-44c7-4efa-a5b8-dd61b39a1b4f", seedCustomerRoleID, seedReadID, false, "", Validity{}},
+	} {
+		if err == nil {
+			err = store.InsertRolePermission(rp)
+		}
+	}
+	for _, er := range []EntityRole{
+		{"63948426-c016-4fa9-b2ed-e90589a4deb7", seedAdminEntity, seedAdminRoleID, Resource{}, Validity{}},
+		{"2ff8542c-d34d-491c-a133-238d0bdd12fa", seedCustomerEntity, seedCustomerRoleID, Resource{}, Validity{}},
+	} {
+		if err == nil {
+			err = store.InsertEntityRole(er)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// TestStoreConformance runs the conformance suite against every backend
+func TestStoreConformance(t *testing.T) {
+	for backend, newStore := range testBackends() {
+		t.Run(backend, func(t *testing.T) {
+			testConformance(t, newStore)
+		})
+	}
+}
+
+// testConformance checks that a Store behaves like the postgres schema in
+// migrate.sql. Every case runs against a freshly seeded store.
+func testConformance(t *testing.T, newStore func() Store) {
+	var cases = []struct {
+		Name string
+		Run  func(t *testing.T, P *Permissionist)
+	}{
+		{"apps crud", conformAppsCrud},
+		{"duplicate app name", conformDuplicateAppName},
+		{"roles crud", conformRolesCrud},
+		{"duplicate role name", conformDuplicateRoleName},
+		{"permissions crud", conformPermissionsCrud},
+		{"duplicate permission name", conformDuplicatePermissionName},
+		{"renames", conformRenames},
+		{"missing references", conformMissingReferences},
+		{"missing records", conformMissingRecords},
+		{"malformed uuids", conformMalformedUUIDs},
+		{"role is allowed", conformRoleIsAllowed},
+		{"entity is allowed", conformEntityIsAllowed},
+		{"permission by name", conformPermissionByName},
+		{"checks by name", conformChecksByName},
+		{"batch checks", conformBatchChecks},
+		{"entity roles", conformEntityRoles},
+		{"role inheritance", conformRoleInheritance},
+		{"role inheritance cycles", conformRoleInheritanceCycles},
+		{"resource scoped roles", conformResourceScopedRoles},
+		{"wildcard permissions", conformWildcardPermissions},
+		{"time bound grants", conformTimeBoundGrants},
+		{"groups", conformGroups},
+		{"nested groups", conformNestedGroups},
+		{"conditional grants", conformConditionalGrants},
+		{"role denials", conformRoleDenials},
+		{"entity denials", conformEntityDenials},
+		{"remove app cascades", conformRemoveAppCascades},
+		{"remove role cascades", conformRemoveRoleCascades},
+		{"remove permission cascades", conformRemovePermissionCascades},
+		{"remove group cascades", conformRemoveGroupCascades},
+		{"relation tuples", conformRelationTuples},
+		{"relation rewrites", conformRelationRewrites},
+		{"transactions", conformTransactions},
+		{"policy apply", conformPolicyApply},
+		{"export and import", conformExportImport},
+		{"batches", conformBatches},
+		{"bulk inserts", conformBulkInserts},
+		{"pagination", conformPagination},
+		{"reverse lookups", conformReverseLookups},
+		{"many holders", conformManyHolders},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := newStore()
+			testSeed(store)
+			tc.Run(t, &Permissionist{store})
+		})
+	}
+}
+
+func conformAppsCrud(t *testing.T, P *Permissionist) {
+	app, err := P.CreateApp("BurritoApp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := P.GetApp(app.ID)
+	if err != nil || got != app {
+		t.Errorf("Expected app %v got %v [%v]", app, got, err)
+	}
+	apps, err := P.GetApps()
+	if err != nil || len(apps) != 2 {
+		t.Errorf("Expected 2 apps got %d [%v]", len(apps), err)
+	}
+	if _, err := P.GetApp(missingID); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound getting a missing app")
+	}
+	if err := P.RemoveApp(app.ID); err != nil {
+		t.Error(err)
+	}
+	if _, err := P.GetApp(app.ID); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound getting a removed app")
+	}
+}
+
+func conformDuplicateAppName(t *testing.T, P *Permissionist) {
+	if _, err := P.CreateApp("TacoApp"); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate creating a duplicate app")
+	}
+}
+
+func conformRolesCrud(t *testing.T, P *Permissionist) {
+	roles, err := P.CreateRoles([]string{"cook", "waiter"}, seedAppID)
+	if err != nil || len(roles) != 2 {
+		t.Fatalf("Expected 2 roles got %d [%v]", len(roles), err)
+	}
+	got, err := P.GetRoleByID(roles[0].ID)
+	if err != nil || got != roles[0] {
+		t.Errorf("Expected role %v got %v [%v]", roles[0], got, err)
+	}
+	all, err := P.GetRolesByAppID(seedAppID)
+	if err != nil || len(all) != 4 {
+		t.Errorf("Expected 4 roles got %d [%v]", len(all), err)
+	}
+	if _, err := P.GetRoleByID(missingID); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound getting a missing role")
+	}
+	other, err := P.CreateApp("BurritoApp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.CreateRole("admin", other.ID); err != nil {
+		t.Errorf("Expected role names to be unique per app [%v]", err)
+	}
+}
+
+func conformDuplicateRoleName(t *testing.T, P *Permissionist) {
+	if _, err := P.CreateRole("admin", seedAppID); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate creating a duplicate role")
+	}
+	if _, err := P.CreateRoles([]string{"cook", "cook"}, seedAppID); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate creating duplicate roles in one batch")
+	}
+	if _, err := P.CreateRoles([]string{"cook", "customer"}, seedAppID); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate creating a batch with an existing role")
+	}
+	roles, _ := P.GetRolesByAppID(seedAppID)
+	if len(roles) != 2 {
+		t.Errorf("Expected failed batches to insert nothing, got %d roles", len(roles))
+	}
+}
+
+func conformPermissionsCrud(t *testing.T, P *Permissionist) {
+	perms, err := P.CreatePermissions([]string{"eat", "cook"}, seedAppID)
+	if err != nil || len(perms) != 2 {
+		t.Fatalf("Expected 2 permissions got %d [%v]", len(perms), err)
+	}
+	if err := P.AssignPermissionToRole(seedCustomerRoleID, perms[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := P.GetPermissionsByRoleID(seedCustomerRoleID)
+	if err != nil || len(got) != 2 {
+		t.Errorf("Expected 2 permissions got %d [%v]", len(got), err)
+	}
+	if err := P.UnassignPermissionFromRole(seedCustomerRoleID, perms[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err = P.GetPermissionsByRoleID(seedCustomerRoleID)
+	if err != nil || len(got) != 1 {
+		t.Errorf("Expected 1 permission got %d [%v]", len(got), err)
+	}
+}
+
+func conformDuplicatePermissionName(t *testing.T, P *Permissionist) {
+	if _, err := P.CreatePermission("read", seedAppID); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate creating a duplicate permission")
+	}
+	if _, err := P.CreatePermissions([]string{"eat", "read"}, seedAppID); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate creating a batch with an existing permission")
+	}
+	if err := P.RemovePermission(seedReadID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.CreatePermission("read", seedAppID); err != nil {
+		t.Errorf("Expected a removed permission name to be reusable [%v]", err)
+	}
+}
+
+func conformRenames(t *testing.T, P *Permissionist) {
+	app, err := P.RenameApp(seedAppID, "TortaApp")
+	if err != nil || app.Name != "TortaApp" {
+		t.Errorf("Expected app renamed TortaApp got %v [%v]", app, err)
+	}
+	if got, _ := P.GetApp(seedAppID); got.Name != "TortaApp" {
+		t.Errorf("Expected renamed app to be stored got %v", got)
+	}
+	role, err := P.RenameRole(seedCustomerRoleID, "diner")
+	if err != nil || role.Name != "diner" || role.AppID != seedAppID {
+		t.Errorf("Expected role renamed diner in its app got %v [%v]", role, err)
+	}
+	perm, err := P.RenamePermission(seedReadID, "view")
+	if err != nil || perm.Name != "view" || perm.AppID != seedAppID {
+		t.Errorf("Expected permission renamed view in its app got %v [%v]", perm, err)
+	}
+	if allowed, _ := P.EntityIsAllowed(seedCustomerEntity, seedReadID); !allowed {
+		t.Error("Expected grants to survive a rename")
+	}
+	if _, err := P.RenameRole(seedCustomerRoleID, "diner"); err != nil {
+		t.Errorf("Expected renaming to the same name to succeed [%v]", err)
+	}
+	if _, err := P.RenameRole(seedCustomerRoleID, "admin"); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate renaming a role to a taken name")
+	}
+	if _, err := P.RenamePermission(seedReadID, "write"); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate renaming a permission to a taken name")
+	}
+	other, err := P.CreateApp("BurritoApp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.RenameApp(other.ID, "TortaApp"); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate renaming an app to a taken name")
+	}
+	if _, err := P.RenameApp(missingID, "NachoApp"); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound renaming a missing app")
+	}
+	if _, err := P.RenameRole(missingID, "cook"); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound renaming a missing role")
+	}
+	if _, err := P.RenamePermission(missingID, "eat"); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound renaming a missing permission")
+	}
+	if _, err := P.RenameRole(seedAdminRoleID, ""); errors.Cause(err) != ErrMissingField {
+		t.Error("Expected ErrMissingField renaming a role to nothing")
+	}
+	if _, err := P.GetPermission(missingID); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound getting a missing permission")
+	}
+	perms, err := P.GetPermissionsByAppID(seedAppID)
+	if err != nil || len(perms) != 3 {
+		t.Errorf("Expected 3 permissions got %d [%v]", len(perms), err)
+	}
+}
+
+func conformMissingReferences(t *testing.T, P *Permissionist) {
+	if _, err := P.CreateRole("cook", missingID); errors.Cause(err) != ErrMissingReference {
+		t.Error("Expected ErrMissingReference creating a role for a missing app")
+	}
+	if _, err := P.CreatePermission("eat", missingID); errors.Cause(err) != ErrMissingReference {
+		t.Error("Expected ErrMissingReference creating a permission for a missing app")
+	}
+	if err := P.AssignPermissionToRole(missingID, seedReadID); errors.Cause(err) != ErrMissingReference {
+		t.Error("Expected ErrMissingReference granting a permission to a missing role")
+	}
+	if err := P.AssignPermissionToRole(seedAdminRoleID, missingID); errors.Cause(err) != ErrMissingReference {
+		t.Error("Expected ErrMissingReference granting a missing permission")
+	}
+	if err := P.AssignRoleToEntity("some entity", missingID, Resource{}); errors.Cause(err) != ErrMissingReference {
+		t.Error("Expected ErrMissingReference assigning a missing role")
+	}
+}
+
+func conformMissingRecords(t *testing.T, P *Permissionist) {
+	staff, err := P.CreateGroup("staff", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := map[string]error{}
+	checks["RemoveApp"] = P.RemoveApp(missingID)
+	checks["RemoveRole"] = P.RemoveRole(missingID)
+	checks["RemovePermission"] = P.RemovePermission(missingID)
+	checks["UnassignPermissionFromRole"] = P.UnassignPermissionFromRole(seedCustomerRoleID, seedWriteID)
+	checks["RemoveRoleDenial"] = P.RemoveRoleDenial(seedAdminRoleID, seedReadID)
+	checks["UnassignParentFromRole"] = P.UnassignParentFromRole(seedAdminRoleID, seedCustomerRoleID)
+	checks["RemoveEntityDenial"] = P.RemoveEntityDenial(seedAdminEntity, seedReadID)
+	checks["RemoveGroup"] = P.RemoveGroup(missingID)
+	checks["RemoveEntityFromGroup"] = P.RemoveEntityFromGroup(staff.ID, seedAdminEntity)
+	checks["UnnestGroup"] = P.UnnestGroup(staff.ID, missingID)
+	checks["UnassignRoleFromGroup"] = P.UnassignRoleFromGroup(staff.ID, seedAdminRoleID)
+	checks["DeleteRelationTuple"] = P.DeleteRelationTuple(RelationTuple{Namespace: "doc", ObjectID: "1", Relation: "viewer", SubjectID: seedAdminEntity})
+	for method, err := range checks {
+		if errors.Cause(err) != ErrNotFound {
+			t.Errorf("Expected ErrNotFound from %s with a missing record got %v", method, err)
+		}
+	}
+}
+
+func conformMalformedUUIDs(t *testing.T, P *Permissionist) {
+	bad := "bad id"
+	checks := map[string]error{}
+	_, checks["GetApp"] = P.GetApp(bad)
+	checks["RemoveApp"] = P.RemoveApp(bad)
+	_, checks["CreateRole"] = P.CreateRole("cook", bad)
+	_, checks["GetRoleByID"] = P.GetRoleByID(bad)
+	_, checks["GetRolesByAppID"] = P.GetRolesByAppID(bad)
+	checks["RemoveRole"] = P.RemoveRole(bad)
+	_, checks["CreatePermission"] = P.CreatePermission("eat", bad)
+	_, checks["GetPermissionsByRoleID"] = P.GetPermissionsByRoleID(bad)
+	_, checks["GetPermissionsByEntityID"] = P.GetPermissionsByEntityID(seedAdminEntity, bad)
+	checks["RemovePermission"] = P.RemovePermission(bad)
+	checks["AssignPermissionToRole"] = P.AssignPermissionToRole(bad, seedReadID)
+	checks["UnassignPermissionFromRole"] = P.UnassignPermissionFromRole(seedAdminRoleID, bad)
+	checks["AssignRoleToEntity"] = P.AssignRoleToEntity("some entity", bad, Resource{})
+	checks["UnassignRoleFromEntity"] = P.UnassignRoleFromEntity("some entity", bad, Resource{})
+	_, checks["RoleIsAllowed"] = P.RoleIsAllowed(bad, seedReadID)
+	_, checks["EntityIsAllowed"] = P.EntityIsAllowed(seedAdminEntity, bad)
+	for method, err := range checks {
+		if errors.Cause(err) != ErrInvalidID {
+			t.Errorf("Expected ErrInvalidID from %s with a malformed id got %v", method, err)
+		}
+	}
+}
+
+func conformRoleIsAllowed(t *testing.T, P *Permissionist) {
+	var cases = []struct {
+		RoleID       string
+		PermissionID string
+		Expected     bool
+	}{
+		{seedAdminRoleID, seedDeleteID, true},
+		{seedCustomerRoleID, seedReadID, true},
+		{seedCustomerRoleID, seedDeleteID, false},
+		{missingID, seedReadID, false},
+		{seedAdminRoleID, missingID, false},
+	}
+	for _, tc := range cases {
+		allowed, err := P.RoleIsAllowed(tc.RoleID, tc.PermissionID)
+		if err != nil || allowed != tc.Expected {
+			t.Errorf("RoleIsAllowed(%s, %s) expected '%t' got '%t' [%v]", tc.RoleID, tc.PermissionID, tc.Expected, allowed, err)
+		}
+	}
+}
+
+func conformEntityIsAllowed(t *testing.T, P *Permissionist) {
+	var cases = []struct {
+		EntityID     string
+		PermissionID string
+		Expected     bool
+	}{
+		{seedAdminEntity, seedDeleteID, true},
+		{seedCustomerEntity, seedReadID, true},
+		{seedCustomerEntity, seedDeleteID, false},
+		{"nobody", seedReadID, false},
+		{seedAdminEntity, missingID, false},
+	}
+	for _, tc := range cases {
+		allowed, err := P.EntityIsAllowed(tc.EntityID, tc.PermissionID)
+		if err != nil || allowed != tc.Expected {
+			t.Errorf("EntityIsAllowed(%s, %s) expected '%t' got '%t' [%v]", tc.EntityID, tc.PermissionID, tc.Expected, allowed, err)
+		}
+	}
+}
+
+func conformPermissionByName(t *testing.T, P *Permissionist) {
+	perm, err := P.GetPermissionByName(seedAppID, "write")
+	if err != nil || perm.ID != seedWriteID {
+		t.Errorf("Expected permission %s got %s [%v]", seedWriteID, perm.ID, err)
+	}
+	if _, err := P.GetPermissionByName(seedAppID, "eat"); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected ErrNotFound getting a missing permission name got %v", err)
+	}
+}
+
+func conformChecksByName(t *testing.T, P *Permissionist) {
+	var cases = []struct {
+		EntityID       string
+		AppRef         string
+		PermissionName string
+		Expected       bool
+		Err            error
+	}{
+		{seedAdminEntity, "TacoApp", "delete", true, nil},
+		{seedCustomerEntity, "TacoApp", "delete", false, nil},
+		{seedCustomerEntity, seedAppID, "read", true, nil},
+		{seedCustomerEntity, "TacoApp", "eat", false, ErrNotFound},
+		{seedCustomerEntity, "BurritoApp", "read", false, ErrNotFound},
+	}
+	for _, tc := range cases {
+		check, err := P.EntityIsAllowedByName(tc.EntityID, tc.AppRef, tc.PermissionName)
+		if errors.Cause(err) != tc.Err || check.Allowed != tc.Expected {
+			t.Errorf("EntityIsAllowedByName(%s, %s, %s) expected '%t' got '%t' [%v]", tc.EntityID, tc.AppRef, tc.PermissionName, tc.Expected, check.Allowed, err)
+		}
+	}
+	check, err := P.RoleIsAllowedByName(seedCustomerRoleID, "read")
+	if err != nil || !check.Allowed || check.PermissionID != seedReadID {
+		t.Errorf("Expected customer to be allowed %s got %v [%v]", seedReadID, check, err)
+	}
+	check, err = P.RoleIsAllowedByName(seedCustomerRoleID, "write")
+	if err != nil || check.Allowed {
+		t.Errorf("Expected customer not to be allowed write [%v]", err)
+	}
+}
+
+func conformBatchChecks(t *testing.T, P *Permissionist) {
+	results, err := P.EntitiesAreAllowed([]Check{
+		{EntityID: seedAdminEntity, PermissionID: seedDeleteID},
+		{EntityID: seedAdminEntity, PermissionID: seedReadID},
+		{EntityID: seedCustomerEntity, PermissionID: seedReadID},
+		{EntityID: seedCustomerEntity, PermissionID: seedDeleteID},
+		{EntityID: "nobody", PermissionID: seedReadID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]bool{
+		seedAdminEntity:    {seedDeleteID: true, seedReadID: true},
+		seedCustomerEntity: {seedReadID: true, seedDeleteID: false},
+		"nobody":           {seedReadID: false},
+	}
+	for entityID, perms := range expected {
+		for permissionID, allowed := range perms {
+			got, ok := results[entityID][permissionID]
+			if !ok || got != allowed {
+				t.Errorf("Expected %s/%s to be '%t' got '%t' (present %t)", entityID, permissionID, allowed, got, ok)
+			}
+		}
+	}
+	if _, err := P.EntitiesAreAllowed([]Check{{EntityID: seedAdminEntity, PermissionID: "bad id"}}); errors.Cause(err) != ErrInvalidID {
+		t.Errorf("Expected ErrInvalidID got %v", err)
+	}
+	if results, err := P.EntitiesAreAllowed(nil); err != nil || len(results) != 0 {
+		t.Errorf("Expected an empty batch to return nothing got %v [%v]", results, err)
+	}
+}
+
+func conformEntityRoles(t *testing.T, P *Permissionist) {
+	if err := P.AssignRoleToEntity(seedCustomerEntity, seedAdminRoleID, Resource{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignRoleToEntity(seedCustomerEntity, seedAdminRoleID, Resource{}); errors.Cause(err) != ErrDuplicate {
+		t.Error("Expected ErrDuplicate assigning a role twice")
+	}
+	roles, err := P.GetRolesByEntityID(seedCustomerEntity)
+	if err != nil || len(roles) != 2 {
+		t.Errorf("Expected 2 roles got %d [%v]", len(roles), err)
+	}
+	allowed, err := P.EntityIsAllowed(seedCustomerEntity, seedDeleteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected assigned role to grant delete [%v]", err)
+	}
+	apps, err := P.GetAppsByEntityID(seedCustomerEntity)
+	if err != nil || len(apps) == 0 || apps[0].ID != seedAppID {
+		t.Errorf("Expected entity to be in app %s got %v [%v]", seedAppID, apps, err)
+	}
+	if err := P.UnassignRoleFromEntity(seedCustomerEntity, seedAdminRoleID, Resource{}); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.EntityIsAllowed(seedCustomerEntity, seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected unassigned role to stop granting delete [%v]", err)
+	}
+	if err := P.UnassignRoleFromEntity(seedCustomerEntity, seedAdminRoleID, Resource{}); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected ErrNotFound unassigning a role twice got %v", err)
+	}
+	if err := P.UnassignRoleFromEntity(seedCustomerEntity, missingID, Resource{}); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected ErrNotFound unassigning an unknown role got %v", err)
+	}
+}
+
+func conformRoleInheritance(t *testing.T, P *Permissionist) {
+	// manager inherits admin, which inherits customer
+	manager, err := P.CreateRole("manager", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.UnassignPermissionFromRole(seedAdminRoleID, seedReadID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(seedAdminRoleID, seedCustomerRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(manager.ID, seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(manager.ID, seedAdminRoleID); errors.Cause(err) != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate assigning a parent twice got %v", err)
+	}
+	if err := P.AssignRoleToEntity("some manager", manager.ID, Resource{}); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed, err := P.RoleIsAllowed(manager.ID, seedReadID)
+	if err != nil || !allowed {
+		t.Errorf("Expected manager to inherit read from customer [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowed("some manager", seedDeleteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected entity to inherit delete from admin [%v]", err)
+	}
+	allowed, err = P.RoleIsAllowed(seedCustomerRoleID, seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected customer not to inherit from its children [%v]", err)
+	}
+	perms, err := P.GetPermissionsByEntityID("some manager", seedAppID)
+	if err != nil || len(perms) != 3 {
+		t.Errorf("Expected 3 inherited permissions got %d [%v]", len(perms), err)
+	}
+	parents, err := P.GetParentRoles(manager.ID)
+	if err != nil || len(parents) != 1 || parents[0].ID != seedAdminRoleID {
+		t.Errorf("Expected manager's parent to be admin got %v [%v]", parents, err)
+	}
+
+	if err := P.UnassignParentFromRole(seedAdminRoleID, seedCustomerRoleID); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.EntityIsAllowed("some manager", seedReadID)
+	if err != nil || allowed {
+		t.Errorf("Expected read to stop being inherited [%v]", err)
+	}
+	if err := P.RemoveRole(seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	parents, err = P.GetParentRoles(manager.ID)
+	if err != nil || len(parents) != 0 {
+		t.Errorf("Expected parent links to be removed with their role got %v [%v]", parents, err)
+	}
+}
+
+func conformRoleInheritanceCycles(t *testing.T, P *Permissionist) {
+	manager, err := P.CreateRole("manager", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(seedAdminRoleID, seedCustomerRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(manager.ID, seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(seedCustomerRoleID, manager.ID); errors.Cause(err) != ErrRoleCycle {
+		t.Errorf("Expected ErrRoleCycle got %v", err)
+	}
+	if err := P.AssignParentToRole(seedCustomerRoleID, seedCustomerRoleID); errors.Cause(err) != ErrRoleCycle {
+		t.Errorf("Expected ErrRoleCycle for a self parent got %v", err)
+	}
+	other, err := P.CreateApp("BurritoApp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chef, err := P.CreateRole("chef", other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(chef.ID, seedAdminRoleID); errors.Cause(err) != ErrAppMismatch {
+		t.Errorf("Expected ErrAppMismatch got %v", err)
+	}
+	if err := P.AssignParentToRole(chef.ID, missingID); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing parent got %v", err)
+	}
+}
+
+func conformResourceScopedRoles(t *testing.T, P *Permissionist) {
+	project42 := Resource{Type: "project", ID: "42"}
+	project43 := Resource{Type: "project", ID: "43"}
+	if err := P.AssignRoleToEntity(seedCustomerEntity, seedAdminRoleID, project42); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignRoleToEntity(seedCustomerEntity, seedAdminRoleID, project43); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignRoleToEntity(seedCustomerEntity, seedAdminRoleID, project42); errors.Cause(err) != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate assigning a role twice on a resource got %v", err)
+	}
+	if err := P.AssignRoleToEntity(seedCustomerEntity, seedAdminRoleID, Resource{Type: "project"}); errors.Cause(err) != ErrInvalidResource {
+		t.Errorf("Expected ErrInvalidResource got %v", err)
+	}
+
+	allowed, err := P.EntityIsAllowedOn(seedCustomerEntity, seedDeleteID, project42)
+	if err != nil || !allowed {
+		t.Errorf("Expected entity to be allowed delete on project 42 [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowedOn(seedCustomerEntity, seedDeleteID, Resource{Type: "project", ID: "44"})
+	if err != nil || allowed {
+		t.Errorf("Expected entity not to be allowed delete on project 44 [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowedOn(seedCustomerEntity, seedReadID, Resource{Type: "project", ID: "44"})
+	if err != nil || !allowed {
+		t.Errorf("Expected unscoped grants to apply on any resource [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowed(seedCustomerEntity, seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected scoped roles not to apply without a resource [%v]", err)
+	}
+	roles, err := P.GetRolesByEntityID(seedCustomerEntity)
+	if err != nil || len(roles) != 2 {
+		t.Errorf("Expected each role to be listed once got %d [%v]", len(roles), err)
+	}
+
+	if err := P.UnassignRoleFromEntity(seedCustomerEntity, seedAdminRoleID, project42); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.EntityIsAllowedOn(seedCustomerEntity, seedDeleteID, project42)
+	if err != nil || allowed {
+		t.Errorf("Expected role to be unassigned on project 42 [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowedOn(seedCustomerEntity, seedDeleteID, project43)
+	if err != nil || !allowed {
+		t.Errorf("Expected role to stay assigned on project 43 [%v]", err)
+	}
+}
+
+func conformWildcardPermissions(t *testing.T, P *Permissionist) {
+	perms, err := P.CreatePermissions([]string{"documents:*", "documents:read", "documents:delete"}, seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all, read, remove Permission
+	for _, perm := range perms {
+		switch perm.Name {
+		case "documents:*":
+			all = perm
+		case "documents:read":
+			read = perm
+		case "documents:delete":
+			remove = perm
+		}
+	}
+	if err := P.AssignPermissionToRole(seedCustomerRoleID, all.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.DenyPermissionToRole(seedCustomerRoleID, remove.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed, err := P.EntityIsAllowed(seedCustomerEntity, read.ID)
+	if err != nil || !allowed {
+		t.Errorf("Expected documents:* to cover documents:read [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowed(seedCustomerEntity, remove.ID)
+	if err != nil || allowed {
+		t.Errorf("Expected denial to win over the wildcard [%v]", err)
+	}
+	later, err := P.CreatePermission("documents:share", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.RoleIsAllowed(seedCustomerRoleID, later.ID)
+	if err != nil || !allowed {
+		t.Errorf("Expected documents:* to cover permissions created later [%v]", err)
+	}
+	results, err := P.EntitiesAreAllowed([]Check{
+		{EntityID: seedCustomerEntity, PermissionID: later.ID},
+		{EntityID: seedCustomerEntity, PermissionID: seedWriteID},
+	})
+	if err != nil || !results[seedCustomerEntity][later.ID] || results[seedCustomerEntity][seedWriteID] {
+		t.Errorf("Expected batch checks to match wildcards got %v [%v]", results, err)
+	}
+	listed, err := P.GetPermissionsByEntityID(seedCustomerEntity, seedAppID)
+	if err != nil || len(listed) != 4 {
+		t.Errorf("Expected read plus three documents permissions got %v [%v]", listed, err)
+	}
+
+	other, err := P.CreateApp("BurritoApp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := P.CreatePermission("documents:read", other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.EntityIsAllowed(seedCustomerEntity, foreign.ID)
+	if err != nil || allowed {
+		t.Errorf("Expected wildcards not to cross apps [%v]", err)
+	}
+}
+
+func conformTimeBoundGrants(t *testing.T, P *Permissionist) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	if err := P.AssignTemporaryRoleToEntity("expired", seedAdminRoleID, Resource{}, Validity{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignTemporaryRoleToEntity("scheduled", seedAdminRoleID, Resource{}, Validity{NotBefore: &future}); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignTemporaryRoleToEntity("on call", seedAdminRoleID, Resource{}, Validity{NotBefore: &past, ExpiresAt: &future}); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignTemporaryPermissionToRole(seedCustomerRoleID, seedWriteID, Validity{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignTemporaryRoleToEntity("backwards", seedAdminRoleID, Resource{}, Validity{NotBefore: &future, ExpiresAt: &past}); errors.Cause(err) != ErrInvalidValidity {
+		t.Errorf("Expected ErrInvalidValidity got %v", err)
+	}
+
+	for _, tc := range []struct {
+		EntityID string
+		Expected bool
+	}{
+		{"expired", false},
+		{"scheduled", false},
+		{"on call", true},
+	} {
+		allowed, err := P.EntityIsAllowed(tc.EntityID, seedDeleteID)
+		if err != nil || allowed != tc.Expected {
+			t.Errorf("Expected %s to be allowed %v got %v [%v]", tc.EntityID, tc.Expected, allowed, err)
+		}
+	}
+	allowed, err := P.RoleIsAllowed(seedCustomerRoleID, seedWriteID)
+	if err != nil || allowed {
+		t.Errorf("Expected expired role permission to be ignored [%v]", err)
+	}
+
+	report, err := P.SweepExpired(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.EntityRoles) != 1 || report.EntityRoles[0].EntityID != "expired" {
+		t.Errorf("Expected the expired assignment to be swept got %v", report.EntityRoles)
+	}
+	if len(report.RolePermissions) != 1 || report.RolePermissions[0].PermissionID != seedWriteID {
+		t.Errorf("Expected the expired role permission to be swept got %v", report.RolePermissions)
+	}
+	roles, err := P.GetRolesByEntityID("on call")
+	if err != nil || len(roles) != 1 {
+		t.Errorf("Expected unexpired assignments to be kept got %d [%v]", len(roles), err)
+	}
+
+	reports := make(chan SweepReport, 1)
+	sweeper := P.StartSweeper(10*time.Millisecond, func(report SweepReport, err error) {
+		if err == nil && len(report.EntityRoles) > 0 {
+			select {
+			case reports <- report:
+			default:
+			}
+		}
+	})
+	if err := P.AssignTemporaryRoleToEntity("brief", seedAdminRoleID, Resource{}, Validity{ExpiresAt: &now}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case report := <-reports:
+		if len(report.EntityRoles) != 1 || report.EntityRoles[0].EntityID != "brief" {
+			t.Errorf("Expected the sweeper to remove the brief assignment got %v", report.EntityRoles)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the sweeper to report a removal")
+	}
+	sweeper.Stop()
+}
+
+func conformGroups(t *testing.T, P *Permissionist) {
+	team, err := P.CreateGroup("team", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.CreateGroup("team", seedAppID); errors.Cause(err) != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate for a duplicate group name got %v", err)
+	}
+	if _, err := P.CreateGroup("team", missingID); errors.Cause(err) != ErrMissingReference {
+		t.Errorf("Expected ErrMissingReference for a missing app got %v", err)
+	}
+	if err := P.AddEntityToGroup(team.ID, "member"); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AddEntityToGroup(team.ID, "member"); errors.Cause(err) != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate adding a member twice got %v", err)
+	}
+	if err := P.AddEntityToGroup(missingID, "member"); errors.Cause(err) != ErrMissingReference {
+		t.Errorf("Expected ErrMissingReference for a missing group got %v", err)
+	}
+	if err := P.AssignRoleToGroup(team.ID, seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	other, err := P.CreateApp("BurritoApp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chef, err := P.CreateRole("chef", other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignRoleToGroup(team.ID, chef.ID); errors.Cause(err) != ErrAppMismatch {
+		t.Errorf("Expected ErrAppMismatch got %v", err)
+	}
+
+	allowed, err := P.EntityIsAllowed("member", seedDeleteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected member to hold the group's role [%v]", err)
+	}
+	roles, err := P.GetRolesByEntityID("member")
+	if err != nil || len(roles) != 1 || roles[0].ID != seedAdminRoleID {
+		t.Errorf("Expected member's roles to include the group's got %v [%v]", roles, err)
+	}
+	perms, err := P.GetPermissionsByEntityID("member", seedAppID)
+	if err != nil || len(perms) != 3 {
+		t.Errorf("Expected 3 permissions through the group got %d [%v]", len(perms), err)
+	}
+	if err := P.AddEntityToGroup(team.ID, seedAdminEntity); err != nil {
+		t.Fatal(err)
+	}
+	roles, err = P.GetRolesByEntityID(seedAdminEntity)
+	if err != nil || len(roles) != 1 {
+		t.Errorf("Expected a role held twice to be listed once got %v [%v]", roles, err)
+	}
+	members, err := P.GetGroupMembers(team.ID)
+	if err != nil || len(members) != 2 {
+		t.Errorf("Expected 2 members got %v [%v]", members, err)
+	}
+	groups, err := P.GetGroupsByAppID(seedAppID)
+	if err != nil || len(groups) != 1 || groups[0].ID != team.ID {
+		t.Errorf("Expected the app's groups to be listed got %v [%v]", groups, err)
+	}
+
+	if err := P.RemoveEntityFromGroup(team.ID, "member"); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.EntityIsAllowed("member", seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected removed member to lose the group's role [%v]", err)
+	}
+	if err := P.UnassignRoleFromGroup(team.ID, seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	roles, err = P.GetRolesByGroupID(team.ID)
+	if err != nil || len(roles) != 0 {
+		t.Errorf("Expected the group's role to be unassigned got %v [%v]", roles, err)
+	}
+}
+
+func conformNestedGroups(t *testing.T, P *Permissionist) {
+	// oncall is nested in engineering, which is nested in staff
+	var groups []Group
+	for _, name := range []string{"staff", "engineering", "oncall"} {
+		group, err := P.CreateGroup(name, seedAppID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups = append(groups, group)
+	}
+	staff, engineering, oncall := groups[0], groups[1], groups[2]
+	if err := P.NestGroup(engineering.ID, staff.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.NestGroup(oncall.ID, engineering.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.NestGroup(staff.ID, oncall.ID); errors.Cause(err) != ErrGroupCycle {
+		t.Errorf("Expected ErrGroupCycle got %v", err)
+	}
+	if err := P.NestGroup(staff.ID, staff.ID); errors.Cause(err) != ErrGroupCycle {
+		t.Errorf("Expected ErrGroupCycle for a self parent got %v", err)
+	}
+	if err := P.AssignRoleToGroup(staff.ID, seedCustomerRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignRoleToGroup(engineering.ID, seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AddEntityToGroup(oncall.ID, "pager"); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AddEntityToGroup(staff.ID, "receptionist"); err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := P.GetRolesByEntityID("pager")
+	if err != nil || len(roles) != 2 {
+		t.Errorf("Expected roles of every enclosing group got %v [%v]", roles, err)
+	}
+	allowed, err := P.EntityIsAllowed("pager", seedDeleteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected nested member to hold engineering's role [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowed("receptionist", seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected roles not to flow into nested groups [%v]", err)
+	}
+
+	if err := P.UnnestGroup(oncall.ID, engineering.ID); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.EntityIsAllowed("pager", seedReadID)
+	if err != nil || allowed {
+		t.Errorf("Expected unnested member to lose inherited roles [%v]", err)
+	}
+}
+
+func conformConditionalGrants(t *testing.T, P *Permissionist) {
+	if err := P.AssignConditionalPermissionToRole(seedCustomerRoleID, seedWriteID, "region == caller.region"); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignConditionalPermissionToRole(seedCustomerRoleID, seedDeleteID, "region =="); errors.Cause(err) != ErrInvalidCondition {
+		t.Errorf("Expected ErrInvalidCondition got %v", err)
+	}
+
+	for _, tc := range []struct {
+		Context  Context
+		Expected bool
+	}{
+		{Context{"region": "eu", "caller": map[string]interface{}{"region": "eu"}}, true},
+		{Context{"region": "eu", "caller": map[string]interface{}{"region": "us"}}, false},
+		{Context{"region": "eu"}, false},
+		{nil, false},
+	} {
+		allowed, err := P.EntityIsAllowedInContext(seedCustomerEntity, seedWriteID, Resource{}, tc.Context)
+		if err != nil || allowed != tc.Expected {
+			t.Errorf("Expected write in context %v to be %v got %v [%v]", tc.Context, tc.Expected, allowed, err)
+		}
+	}
+	allowed, err := P.EntityIsAllowed(seedCustomerEntity, seedWriteID)
+	if err != nil || allowed {
+		t.Errorf("Expected conditional grants not to apply without a context [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowedInContext(seedCustomerEntity, seedReadID, Resource{}, Context{})
+	if err != nil || !allowed {
+		t.Errorf("Expected unconditioned grants to apply in any context [%v]", err)
+	}
+
+	// a negated condition must not hold for lack of information either
+	if err := P.AssignConditionalPermissionToRole(seedCustomerRoleID, seedDeleteID, "!(caller.banned == true)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		Context  Context
+		Expected bool
+	}{
+		{Context{"caller": map[string]interface{}{"banned": false}}, true},
+		{Context{"caller": map[string]interface{}{"banned": true}}, false},
+		{Context{}, false},
+		{nil, false},
+	} {
+		allowed, err := P.EntityIsAllowedInContext(seedCustomerEntity, seedDeleteID, Resource{}, tc.Context)
+		if err != nil || allowed != tc.Expected {
+			t.Errorf("Expected delete in context %v to be %v got %v [%v]", tc.Context, tc.Expected, allowed, err)
+		}
+	}
+	allowed, err = P.EntityIsAllowed(seedCustomerEntity, seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected negated conditional grants not to apply without a context [%v]", err)
+	}
+	allowed, err = P.RoleIsAllowed(seedCustomerRoleID, seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected negated conditional grants not to apply to role checks [%v]", err)
+	}
+	perms, err := P.GetPermissionsByEntityID(seedCustomerEntity, seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, perm := range perms {
+		if perm.ID == seedDeleteID {
+			t.Errorf("Expected conditional grants not to be listed for an entity")
+		}
+	}
+	page, err := P.ListEntitiesByPermissionID(seedDeleteID, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entityID := range page.EntityIDs {
+		if entityID == seedCustomerEntity {
+			t.Errorf("Expected conditional grants not to count in reverse lookups")
+		}
+	}
+}
+
+func conformRoleDenials(t *testing.T, P *Permissionist) {
+	// manager inherits admin, which is denied delete
+	manager, err := P.CreateRole("manager", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignParentToRole(manager.ID, seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignPermissionToRole(manager.ID, seedDeleteID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.DenyPermissionToRole(seedAdminRoleID, seedDeleteID); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed, err := P.RoleIsAllowed(seedAdminRoleID, seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected denial to override admin's grant [%v]", err)
+	}
+	allowed, err = P.RoleIsAllowed(manager.ID, seedDeleteID)
+	if err != nil || allowed {
+		t.Errorf("Expected inherited denial to override manager's grant [%v]", err)
+	}
+	allowed, err = P.EntityIsAllowed(seedAdminEntity, seedWriteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected other grants to be unaffected [%v]", err)
+	}
+	perms, err := P.GetPermissionsByEntityID(seedAdminEntity, seedAppID)
+	if err != nil || len(perms) != 2 {
+		t.Errorf("Expected denied permission to be left out got %d [%v]", len(perms), err)
+	}
+	perms, err = P.GetPermissionsByRoleID(seedAdminRoleID)
+	if err != nil || len(perms) != 3 {
+		t.Errorf("Expected granted permissions to exclude denials got %d [%v]", len(perms), err)
+	}
+	perms, err = P.GetDeniedPermissionsByRoleID(seedAdminRoleID)
+	if err != nil || len(perms) != 1 || perms[0].ID != seedDeleteID {
+		t.Errorf("Expected admin to be denied delete got %v [%v]", perms, err)
+	}
+
+	if err := P.RemoveRoleDenial(seedAdminRoleID, seedDeleteID); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err = P.EntityIsAllowed(seedAdminEntity, seedDeleteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected grant to apply once the denial is removed [%v]", err)
+	}
+}
+
+func conformEntityDenials(t *testing.T, P *Permissionist) {
+	if err := P.DenyPermissionToEntity(seedAdminEntity, seedWriteID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.DenyPermissionToEntity(seedAdminEntity, missingID); errors.Cause(err) != ErrMissingReference {
+		t.Errorf("Expected ErrMissingReference got %v", err)
+	}
+
+	allowed, err := P.EntityIsAllowed(seedAdminEntity, seedWriteID)
+	if err != nil || allowed {
+		t.Errorf("Expected entity denial to override its roles [%v]", err)
+	}
+	allowed, err = P.RoleIsAllowed(seedAdminRoleID, seedWriteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected entity denial not to affect the role [%v]", err)
+	}
+	results, err := P.EntitiesAreAllowed([]Check{
+		{EntityID: seedAdminEntity, PermissionID: seedWriteID},
+		{EntityID: seedAdminEntity, PermissionID: seedReadID},
+	})
+	if err != nil || results[seedAdminEntity][seedWriteID] || !results[seedAdminEntity][seedReadID] {
+		t.Errorf("Expected batch checks to honour entity denials got %v [%v]", results, err)
+	}
+
+	if err := P.RemovePermission(seedWriteID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.RemoveEntityDenial(seedAdminEntity, seedWriteID); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected the denial to be gone with its permission got %v", err)
+	}
+	denials, err := P.Store.GetEntityDenials([]string{seedAdminEntity})
+	if err != nil || len(denials) != 0 {
+		t.Errorf("Expected entity denials to be removed with their permission got %d [%v]", len(denials), err)
+	}
+}
+
+func conformRemoveAppCascades(t *testing.T, P *Permissionist) {
+	if err := P.RemoveApp(seedAppID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.GetRoleByID(seedAdminRoleID); err == nil {
+		t.Error("Expected roles to be removed with their app")
+	}
+	roles, err := P.GetRolesByEntityID(seedAdminEntity)
+	if err != nil || len(roles) != 0 {
+		t.Errorf("Expected entity roles to be removed with their app, got %d [%v]", len(roles), err)
+	}
+	perms, err := P.GetPermissionsByEntityID(seedAdminEntity, seedAppID)
+	if err != nil || len(perms) != 0 {
+		t.Errorf("Expected permissions to be removed with their app, got %d [%v]", len(perms), err)
+	}
+}
+
+func conformRemoveRoleCascades(t *testing.T, P *Permissionist) {
+	if err := P.RemoveRole(seedAdminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := P.GetRolesByEntityID(seedAdminEntity)
+	if err != nil || len(roles) != 0 {
+		t.Errorf("Expected entity roles to be removed with their role, got %d [%v]", len(roles), err)
+	}
+	allowed, err := P.RoleIsAllowed(seedAdminRoleID, seedReadID)
+	if err != nil || allowed {
+		t.Errorf("Expected role permissions to be removed with their role [%v]", err)
+	}
+	if err := P.AssignRoleToEntity(seedAdminEntity, seedCustomerRoleID, Resource{}); err != nil {
+		t.Errorf("Expected other roles to be unaffected [%v]", err)
+	}
+}
+
+func conformRemovePermissionCascades(t *testing.T, P *Permissionist) {
+	if err := P.RemovePermission(seedReadID); err != nil {
+		t.Fatal(err)
+	}
+	perms, err := P.GetPermissionsByRoleID(seedCustomerRoleID)
+	if err != nil || len(perms) != 0 {
+		t.Errorf("Expected role permissions to be removed with their permission, got %d [%v]", len(perms), err)
+	}
+	allowed, err := P.EntityIsAllowed(seedAdminEntity, seedWriteID)
+	if err != nil || !allowed {
+		t.Errorf("Expected other permissions to be unaffected [%v]", err)
+	}
+}
+
+func conformRemoveGroupCascades(t *testing.T, P *Permissionist) {
+	team, err := P.CreateGroup("team", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := P.CreateGroup("parent", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AddEntityToGroup(team.ID, "member"); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.NestGroup(team.ID, parent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignRoleToGroup(parent.ID, seedCustomerRoleID); err != nil {
+		t.Fatal(err)
+	}
+	if err := P.RemoveRole(seedCustomerRoleID); err != nil {
+		t.Fatal(err)
+	}
+	roles, err := P.GetRolesByGroupID(parent.ID)
+	if err != nil || len(roles) != 0 {
+		t.Errorf("Expected group roles to be removed with their role got %v [%v]", roles, err)
+	}
+	if err := P.RemoveGroup(parent.ID); err != nil {
+		t.Fatal(err)
+	}
+	links, err := P.Store.GetGroupParents([]string{team.ID})
+	if err != nil || len(links) != 0 {
+		t.Errorf("Expected nesting to be removed with the parent group got %v [%v]", links, err)
+	}
+	if err := P.RemoveApp(seedAppID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.GetGroup(team.ID); errors.Cause(err) != ErrNotFound {
+		t.Errorf("Expected groups to be removed with their app got %v", err)
+	}
+	memberships, err := P.Store.GetEntityGroups([]string{"member"})
+	if err != nil || len(memberships) != 0 {
+		t.Errorf("Expected memberships to be removed with their group got %v [%v]", memberships, err)
+	}
+}
+
+func conformRelationTuples(t *testing.T, P *Permissionist) {
+	err := P.PutNamespace(Namespace{Name: "group", Relations: map[string]Rewrite{"member": {}}})
+	if err == nil {
+		err = P.PutNamespace(Namespace{Name: "doc", Relations: map[string]Rewrite{"viewer": {}}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tuple := range []RelationTuple{
+		{Namespace: "group", ObjectID: "eng", Relation: "member", SubjectID: "alice"},
+		{Namespace: "doc", ObjectID: "readme", Relation: "viewer", SubjectSet: &SubjectSet{"group", "eng", "member"}},
+	} {
+		if err := P.WriteRelationTuple(tuple); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	duplicate := RelationTuple{Namespace: "group", ObjectID: "eng", Relation: "member", SubjectID: "alice"}
+	if err := P.WriteRelationTuple(duplicate); errors.Cause(err) != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate writing a tuple twice got %v", err)
+	}
+	unknown := RelationTuple{Namespace: "folder", ObjectID: "x", Relation: "viewer", SubjectID: "alice"}
+	if err := P.WriteRelationTuple(unknown); errors.Cause(err) != ErrMissingReference {
+		t.Errorf("Expected ErrMissingReference writing to an unknown namespace got %v", err)
+	}
+	badRelation := RelationTuple{Namespace: "doc", ObjectID: "readme", Relation: "owner", SubjectID: "alice"}
+	if err := P.WriteRelationTuple(badRelation); errors.Cause(err) != ErrInvalidTuple {
+		t.Errorf("Expected ErrInvalidTuple writing an unknown relation got %v", err)
+	}
+
+	for _, subjectID := range []string{"alice", "bob"} {
+		allowed, err := P.CheckRelation("doc", "readme", "viewer", subjectID)
+		if err != nil || allowed != (subjectID == "alice") {
+			t.Errorf("Expected %s viewer of readme to be %v got %v [%v]", subjectID, subjectID == "alice", allowed, err)
+		}
+	}
+
+	if err := P.DeleteRelationTuple(duplicate); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := P.CheckRelation("doc", "readme", "viewer", "alice")
+	if err != nil || allowed {
+		t.Errorf("Expected alice to lose readme with her membership got %v [%v]", allowed, err)
+	}
+}
+
+func conformRelationRewrites(t *testing.T, P *Permissionist) {
+	relations := map[string]Rewrite{
+		"parent": {},
+		"editor": {},
+		"viewer": {
+			ComputedUsersets: []string{"editor"},
+			TupleToUsersets:  []TupleToUserset{{Tupleset: "parent", ComputedUserset: "viewer"}},
+		},
+	}
+	err := P.PutNamespace(Namespace{Name: "folder", Relations: relations})
+	if err == nil {
+		err = P.PutNamespace(Namespace{Name: "doc", Relations: relations})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := Namespace{Name: "doc", Relations: map[string]Rewrite{"viewer": {ComputedUsersets: []string{"owner"}}}}
+	if err := P.PutNamespace(invalid); errors.Cause(err) != ErrInvalidNamespace {
+		t.Errorf("Expected ErrInvalidNamespace rewriting to an unknown relation got %v", err)
+	}
+	for _, tuple := range []RelationTuple{
+		{Namespace: "folder", ObjectID: "root", Relation: "viewer", SubjectID: "carol"},
+		{Namespace: "folder", ObjectID: "docs", Relation: "parent", SubjectSet: &SubjectSet{Namespace: "folder", ObjectID: "root"}},
+		{Namespace: "doc", ObjectID: "readme", Relation: "parent", SubjectSet: &SubjectSet{Namespace: "folder", ObjectID: "docs"}},
+		{Namespace: "doc", ObjectID: "readme", Relation: "editor", SubjectID: "dave"},
+	} {
+		if err := P.WriteRelationTuple(tuple); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var checks = []struct {
+		SubjectID string
+		Relation  string
+		Expected  bool
+	}{
+		{"carol", "viewer", true},
+		{"dave", "viewer", true},
+		{"dave", "editor", true},
+		{"carol", "editor", false},
+		{"erin", "viewer", false},
+	}
+	for _, c := range checks {
+		allowed, err := P.CheckRelation("doc", "readme", c.Relation, c.SubjectID)
+		if err != nil || allowed != c.Expected {
+			t.Errorf("Expected %s %s of readme to be %v got %v [%v]", c.SubjectID, c.Relation, c.Expected, allowed, err)
+		}
+	}
+
+	tree, err := P.ExpandRelation("doc", "readme", "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjects := map[string]bool{}
+	var collect func(tree UsersetTree)
+	collect = func(tree UsersetTree) {
+		for _, subjectID := range tree.Subjects {
+			subjects[subjectID] = true
+		}
+		for _, child := range tree.Children {
+			collect(child)
+		}
+	}
+	collect(tree)
+	if tree.Set != "doc:readme#viewer" || len(subjects) != 2 || !subjects["carol"] || !subjects["dave"] {
+		t.Errorf("Expected readme viewers carol and dave got %v", tree)
+	}
+}
+
+func conformTransactions(t *testing.T, P *Permissionist) {
+	failed := errors.New("fail")
+	err := P.Store.Transact(func(store Store) error {
+		tx := &Permissionist{store}
+		if _, err := tx.CreateApp("BurritoApp"); err != nil {
+			return err
+		}
+		if _, err := tx.CreateRoles([]string{"cook"}, seedAppID); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Errorf("Expected the transaction's error got %v", err)
+	}
+	if apps, err := P.GetApps(); err != nil || len(apps) != 1 {
+		t.Errorf("Expected a failed transaction to create no app got %v [%v]", apps, err)
+	}
+	if roles, err := P.GetRolesByAppID(seedAppID); err != nil || len(roles) != 2 {
+		t.Errorf("Expected a failed transaction to create no role got %v [%v]", roles, err)
+	}
+
+	err = P.Store.Transact(func(store Store) error {
+		return store.Transact(func(store Store) error {
+			_, err := (&Permissionist{store}).CreateApp("BurritoApp")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.Store.GetAppByName("BurritoApp"); err != nil {
+		t.Errorf("Expected a committed transaction to create an app [%v]", err)
+	}
+}
+
+func conformPolicyApply(t *testing.T, P *Permissionist) {
+	policy, err := ParsePolicy([]byte(`
+app: TacoApp
+permissions: [read, write, refund]
+roles:
+  - name: admin
+    permissions: [read, refund]
+  - name: cashier
+    permissions: [refund]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := P.PlanPolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.CreatePermissions) != 1 || len(plan.RemovePermissions) != 1 || plan.RemovePermissions[0].ID != seedDeleteID ||
+		len(plan.CreateRoles) != 1 || len(plan.RemoveRoles) != 1 || plan.RemoveRoles[0].ID != seedCustomerRoleID ||
+		len(plan.Grants) != 2 || len(plan.Revokes) != 1 || plan.Revokes[0] != (PolicyGrant{"admin", "write"}) {
+		t.Errorf("Unexpected plan %+v", plan)
+	}
+	if roles, _ := P.GetRolesByAppID(seedAppID); len(roles) != 2 {
+		t.Error("Expected planning to change nothing")
+	}
+
+	applied, err := P.ApplyPolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied.Grants) != len(plan.Grants) || len(applied.Revokes) != len(plan.Revokes) {
+		t.Errorf("Expected apply to make the planned changes got %+v", applied)
+	}
+	var checks = []struct {
+		Name     string
+		Expected bool
+	}{
+		{"read", true},
+		{"write", false},
+		{"refund", true},
+	}
+	for _, c := range checks {
+		check, err := P.RoleIsAllowedByName(seedAdminRoleID, c.Name)
+		if err != nil || check.Allowed != c.Expected {
+			t.Errorf("Expected admin allowed %s to be %v got %v [%v]", c.Name, c.Expected, check.Allowed, err)
+		}
+	}
+	if _, err := P.GetRoleByID(seedCustomerRoleID); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected a role left out of the policy to be removed")
+	}
+	again, err := P.PlanPolicy(policy)
+	if err != nil || !again.Empty() {
+		t.Errorf("Expected an applied policy to plan no changes got %+v [%v]", again, err)
+	}
+
+	created, err := P.ApplyPolicy(Policy{App: "BurritoApp", Permissions: []string{"eat"}, Roles: []PolicyRole{{"guest", []string{"eat"}}}})
+	if err != nil || !created.CreateApp {
+		t.Fatalf("Expected the app to be created got %+v [%v]", created, err)
+	}
+	app, err := P.Store.GetAppByName("BurritoApp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles, err := P.GetRolesByAppID(app.ID); err != nil || len(roles) != 1 {
+		t.Errorf("Expected 1 role in a created app got %v [%v]", roles, err)
+	}
+}
+
+func conformExportImport(t *testing.T, P *Permissionist) {
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err := P.AssignParentToRole(seedAdminRoleID, seedCustomerRoleID)
+	if err == nil {
+		err = P.AssignConditionalPermissionToRole(seedCustomerRoleID, seedDeleteID, "region == 'eu'")
+	}
+	if err == nil {
+		err = P.AssignTemporaryRoleToEntity("temp", seedCustomerRoleID, Resource{"store", "1"}, Validity{ExpiresAt: &expiresAt})
+	}
+	if err == nil {
+		err = P.DenyPermissionToEntity(seedCustomerEntity, seedReadID)
+	}
+	var staff, interns Group
+	if err == nil {
+		staff, err = P.CreateGroup("staff", seedAppID)
+	}
+	if err == nil {
+		interns, err = P.CreateGroup("interns", seedAppID)
+	}
+	if err == nil {
+		err = P.NestGroup(interns.ID, staff.ID)
+	}
+	if err == nil {
+		err = P.AddEntityToGroup(interns.ID, "intern")
+	}
+	if err == nil {
+		err = P.AssignRoleToGroup(staff.ID, seedAdminRoleID)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a denial overriding a grant, and a grant held only through groups
+	checks := []Check{
+		{EntityID: seedCustomerEntity, PermissionID: seedReadID},
+		{EntityID: "intern", PermissionID: seedDeleteID},
+	}
+	for i, check := range checks {
+		if checks[i].Allowed, err = P.EntityIsAllowed(check.EntityID, check.PermissionID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if checks[0].Allowed || !checks[1].Allowed {
+		t.Fatalf("Unexpected checks before export %+v", checks)
+	}
+
+	export, err := P.ExportApp(seedAppID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Roles) != 2 || len(export.Permissions) != 3 || len(export.RoleParents) != 1 || len(export.EntityRoles) != 3 ||
+		len(export.EntityDenials) != 1 || len(export.Groups) != 2 || len(export.GroupMembers) != 1 || len(export.GroupParents) != 1 || len(export.GroupRoles) != 1 {
+		t.Errorf("Unexpected export %+v", export)
+	}
+	if withoutEntities, err := P.ExportApp(seedAppID, false); err != nil || withoutEntities.EntityRoles != nil || withoutEntities.EntityDenials != nil || withoutEntities.Groups != nil {
+		t.Errorf("Expected no entity records without asking for them got %+v [%v]", withoutEntities, err)
+	}
+	before, _ := MarshalExport(export, "json")
+
+	document, err := MarshalExport(export, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.RemoveApp(seedAppID); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := UnmarshalExport(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.ImportApp(parsed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.ImportApp(parsed); errors.Cause(err) != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate importing an app twice got %v", err)
+	}
+	restored, err := P.ExportApp(seedAppID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := MarshalExport(restored, "json")
+	if string(before) != string(after) {
+		t.Errorf("Expected the import to restore\n%s\ngot\n%s", before, after)
+	}
+	for _, check := range checks {
+		allowed, err := P.EntityIsAllowed(check.EntityID, check.PermissionID)
+		if err != nil || allowed != check.Allowed {
+			t.Errorf("Expected %s allowed %s to stay %v after import got %v [%v]", check.EntityID, check.PermissionID, check.Allowed, allowed, err)
+		}
+	}
+
+	parsed.App.ID = missingID
+	if _, err := P.ImportApp(parsed); errors.Cause(err) != ErrAppMismatch {
+		t.Errorf("Expected ErrAppMismatch importing records of another app got %v", err)
+	}
+}
+
+func conformBatches(t *testing.T, P *Permissionist) {
+	results, err := P.ApplyBatch([]BatchOperation{
+		{Op: "create_app", Ref: "app", Name: "BurritoApp"},
+		{Op: "create_role", Ref: "cook", Name: "cook", AppID: "$app"},
+		{Op: "create_permission", Ref: "grill", Name: "grill", AppID: "$app"},
+		{Op: "assign_permission_to_role", RoleID: "$cook", PermissionID: "$grill"},
+		{Op: "assign_role_to_entity", EntityID: "chef", RoleID: "$cook"},
+	})
+	if err != nil || len(results) != 5 {
+		t.Fatalf("Expected 5 results got %v [%v]", results, err)
+	}
+	allowed, err := P.EntityIsAllowed("chef", results[2].ID)
+	if err != nil || !allowed {
+		t.Errorf("Expected the batch to let chef grill got %v [%v]", allowed, err)
+	}
+
+	appID, cookID := results[0].ID, results[1].ID
+	expiresAt := time.Now().Add(time.Hour)
+	if err := P.PutNamespace(Namespace{Name: "kitchen", Relations: map[string]Rewrite{"cook": {}}}); err != nil {
+		t.Fatal(err)
+	}
+	tuple := RelationTuple{Namespace: "kitchen", ObjectID: "main", Relation: "cook", SubjectID: "chef"}
+	results, err = P.ApplyBatch([]BatchOperation{
+		{Op: "create_permission", Ref: "fry", Name: "fry", AppID: appID},
+		{Op: "create_permission", Ref: "serve", Name: "serve", AppID: appID},
+		{Op: "create_group", Ref: "line", Name: "line", AppID: appID},
+		{Op: "create_group", Ref: "crew", Name: "crew", AppID: appID},
+		{Op: "nest_group", GroupID: "$line", ParentID: "$crew"},
+		{Op: "add_entity_to_group", GroupID: "$line", EntityID: "sous"},
+		{Op: "assign_role_to_group", GroupID: "$crew", RoleID: cookID},
+		{Op: "assign_temporary_permission_to_role", RoleID: cookID, PermissionID: "$fry", Validity: Validity{ExpiresAt: &expiresAt}},
+		{Op: "assign_conditional_permission_to_role", RoleID: cookID, PermissionID: "$serve", Condition: "shift == 'day'"},
+		{Op: "assign_temporary_role_to_entity", EntityID: "temp", RoleID: cookID, Validity: Validity{ExpiresAt: &expiresAt}},
+		{Op: "write_relation_tuple", Tuple: &tuple},
+	})
+	if err != nil || len(results) != 11 {
+		t.Fatalf("Expected 11 results got %v [%v]", results, err)
+	}
+	fryID, serveID, lineID, crewID := results[0].ID, results[1].ID, results[2].ID, results[3].ID
+	for _, check := range []struct {
+		EntityID     string
+		PermissionID string
+		Context      Context
+	}{
+		{"sous", fryID, nil},
+		{"temp", fryID, nil},
+		{"sous", serveID, Context{"shift": "day"}},
+	} {
+		allowed, err := P.EntityIsAllowedInContext(check.EntityID, check.PermissionID, Resource{}, check.Context)
+		if err != nil || !allowed {
+			t.Errorf("Expected the batch to allow %s %s got %v [%v]", check.EntityID, check.PermissionID, allowed, err)
+		}
+	}
+	if allowed, err := P.CheckRelation("kitchen", "main", "cook", "chef"); err != nil || !allowed {
+		t.Errorf("Expected the batch to write a relation tuple got %v [%v]", allowed, err)
+	}
+
+	_, err = P.ApplyBatch([]BatchOperation{
+		{Op: "unassign_role_from_group", GroupID: crewID, RoleID: cookID},
+		{Op: "unnest_group", GroupID: lineID, ParentID: crewID},
+		{Op: "remove_entity_from_group", GroupID: lineID, EntityID: "sous"},
+		{Op: "remove_group", GroupID: crewID},
+		{Op: "delete_relation_tuple", Tuple: &tuple},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed, err := P.EntityIsAllowed("sous", fryID); err != nil || allowed {
+		t.Errorf("Expected the batch to take sous out of the groups got %v [%v]", allowed, err)
+	}
+	if allowed, err := P.CheckRelation("kitchen", "main", "cook", "chef"); err != nil || allowed {
+		t.Errorf("Expected the batch to delete a relation tuple got %v [%v]", allowed, err)
+	}
+
+	var failures = []struct {
+		Operations []BatchOperation
+		Index      int
+		Cause      error
+	}{
+		{[]BatchOperation{{Op: "create_app", Name: "FalafelApp"}, {Op: "create_app", Name: "TacoApp"}}, 1, ErrDuplicate},
+		{[]BatchOperation{{Op: "create_app", Name: "FalafelApp"}, {Op: "create_role", Name: "cook", AppID: "$app"}}, 1, ErrInvalidBatch},
+		{[]BatchOperation{{Op: "create_app", Name: "FalafelApp"}, {Op: "bake"}}, 1, ErrInvalidBatch},
+		{[]BatchOperation{{Op: "create_app", Name: "FalafelApp"}, {Op: "write_relation_tuple"}}, 1, ErrMissingField},
+	}
+	for _, f := range failures {
+		_, err := P.ApplyBatch(f.Operations)
+		batchErr, ok := err.(*BatchError)
+		if !ok || batchErr.Index != f.Index || errors.Cause(err) != f.Cause {
+			t.Errorf("Expected operation %d to fail with %v got %v", f.Index, f.Cause, err)
+		}
+		if _, err := P.Store.GetAppByName("FalafelApp"); errors.Cause(err) != ErrNotFound {
+			t.Error("Expected a failed batch to create nothing")
+		}
+	}
+}
+
+func conformBulkInserts(t *testing.T, P *Permissionist) {
+	names := []string{"o'brien", "robert'); DROP TABLE roles; --", `say "hi"`}
+	for i := 0; i < 2*insertChunkSize; i++ {
+		names = append(names, fmt.Sprintf("role %d", i))
+	}
+	roles, err := P.CreateRoles(names, seedAppID)
+	if err != nil || len(roles) != len(names) {
+		t.Fatalf("Expected %d roles got %d [%v]", len(names), len(roles), err)
+	}
+	for _, name := range names[:3] {
+		role, err := P.Store.GetRole(roles[indexOf(names, name)].ID)
+		if err != nil || role.Name != name {
+			t.Errorf("Expected role %q to round trip got %q [%v]", name, role.Name, err)
+		}
+	}
+	if _, err := P.CreateRoles([]string{"fresh", "o'brien"}, seedAppID); errors.Cause(err) != ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate creating a taken role got %v", err)
+	}
+
+	ensured, err := P.EnsureRoles([]string{"fresh", "o'brien", "admin", "fresh"}, seedAppID)
+	if err != nil || len(ensured) != 1 || ensured[0].Name != "fresh" {
+		t.Errorf("Expected only fresh to be created got %v [%v]", ensured, err)
+	}
+	ensured, err = P.EnsureRoles([]string{"fresh", "o'brien"}, seedAppID)
+	if err != nil || len(ensured) != 0 {
+		t.Errorf("Expected ensuring again to create nothing got %v [%v]", ensured, err)
+	}
+	all, err := P.GetRolesByAppID(seedAppID)
+	if err != nil || len(all) != 2+len(names)+1 {
+		t.Errorf("Expected %d roles got %d [%v]", 2+len(names)+1, len(all), err)
+	}
+
+	perms, err := P.EnsurePermissions([]string{"read", "it's", "it's"}, seedAppID)
+	if err != nil || len(perms) != 1 || perms[0].Name != "it's" {
+		t.Errorf("Expected only it's to be created got %v [%v]", perms, err)
+	}
+	if _, err := P.GetPermissionByName(seedAppID, "it's"); err != nil {
+		t.Error(err)
+	}
+}
+
+func conformPagination(t *testing.T, P *Permissionist) {
+	roles, err := P.CreateRoles([]string{"cook", "cashier", "chef", "clerk", "dishwasher"}, seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		Opts     ListOptions
+		Expected [][]string
+	}{
+		{ListOptions{PageSize: 3}, [][]string{{"admin", "cashier", "chef"}, {"clerk", "cook", "customer"}, {"dishwasher"}}},
+		{ListOptions{PageSize: 2, Prefix: "c"}, [][]string{{"cashier", "chef"}, {"clerk", "cook"}, {"customer"}}},
+		{ListOptions{PageSize: 2, Prefix: "c", OrderBy: "-name"}, [][]string{{"customer", "cook"}, {"clerk", "chef"}, {"cashier"}}},
+		{ListOptions{Prefix: "ch"}, [][]string{{"chef"}}},
+		{ListOptions{Prefix: "x"}, [][]string{{}}},
+	}
+	for _, tc := range cases {
+		opts := tc.Opts
+		for i, expected := range tc.Expected {
+			page, err := P.ListRolesByAppID(seedAppID, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, role := range page.Roles {
+				names = append(names, role.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(expected) {
+				t.Errorf("Expected page %d of %+v to hold %v got %v", i, tc.Opts, expected, names)
+			}
+			if (page.NextPageToken == "") != (i == len(tc.Expected)-1) {
+				t.Errorf("Expected page %d of %+v to have a next page token only if more follow", i, tc.Opts)
+			}
+			opts.PageToken = page.NextPageToken
+		}
+	}
+
+	// ids are listed in order across pages
+	var ids []string
+	opts := ListOptions{PageSize: 2, OrderBy: "id"}
+	for {
+		page, err := P.ListRolesByAppID(seedAppID, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, role := range page.Roles {
+			ids = append(ids, role.ID)
+		}
+		if opts.PageToken = page.NextPageToken; opts.PageToken == "" {
+			break
+		}
+	}
+	if len(ids) != 7 || !sort.StringsAreSorted(ids) {
+		t.Errorf("Expected 7 roles in id order got %v", ids)
+	}
+
+	page, err := P.ListRolesByAppID(seedAppID, ListOptions{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := P.ListRolesByAppID(seedAppID, ListOptions{PageSize: 2, PageToken: page.NextPageToken, Prefix: "c"}); errors.Cause(err) != ErrInvalidPageToken {
+		t.Error("Expected ErrInvalidPageToken reusing a token with another prefix")
+	}
+	if _, err := P.ListRolesByAppID(seedAppID, ListOptions{PageToken: "garbage"}); errors.Cause(err) != ErrInvalidPageToken {
+		t.Error("Expected ErrInvalidPageToken for a malformed token")
+	}
+	if _, err := P.ListRolesByAppID(seedAppID, ListOptions{OrderBy: "app_id"}); errors.Cause(err) != ErrInvalidOrder {
+		t.Error("Expected ErrInvalidOrder ordering by an unknown field")
+	}
+
+	for _, name := range []string{"NachoApp", "BurritoApp"} {
+		if _, err := P.CreateApp(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apps, err := P.ListApps(ListOptions{PageSize: 2})
+	if err != nil || len(apps.Apps) != 2 || apps.Apps[0].Name != "BurritoApp" || apps.NextPageToken == "" {
+		t.Fatalf("Expected a first page of 2 apps got %+v [%v]", apps, err)
+	}
+	apps, err = P.ListApps(ListOptions{PageSize: 2, PageToken: apps.NextPageToken})
+	if err != nil || len(apps.Apps) != 1 || apps.Apps[0].Name != "TacoApp" || apps.NextPageToken != "" {
+		t.Errorf("Expected a last page holding TacoApp got %+v [%v]", apps, err)
+	}
+
+	for _, role := range roles[1:4] {
+		if err := P.AssignRoleToEntity(seedAdminEntity, role.ID, Resource{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entityRoles, err := P.ListRolesByEntityID(seedAdminEntity, ListOptions{PageSize: 3, OrderBy: "-name"})
+	if err != nil || len(entityRoles.Roles) != 3 || entityRoles.Roles[0].Name != "clerk" || entityRoles.NextPageToken == "" {
+		t.Fatalf("Expected a first page of 3 entity roles got %+v [%v]", entityRoles, err)
+	}
+	entityRoles, err = P.ListRolesByEntityID(seedAdminEntity, ListOptions{PageSize: 3, OrderBy: "-name", PageToken: entityRoles.NextPageToken})
+	if err != nil || len(entityRoles.Roles) != 1 || entityRoles.Roles[0].Name != "admin" || entityRoles.NextPageToken != "" {
+		t.Errorf("Expected a last page holding admin got %+v [%v]", entityRoles, err)
+	}
+}
+
+func conformReverseLookups(t *testing.T, P *Permissionist) {
+	staff, err := P.CreateGroup("staff", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interns, err := P.CreateGroup("interns", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := P.CreateRole("manager", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyst, err := P.CreateRole("analyst", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := P.CreatePermissions([]string{"reports:*", "reports:view"}, seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []error{
+		P.AddEntityToGroup(staff.ID, "member"),
+		P.AddEntityToGroup(interns.ID, "nested member"),
+		P.NestGroup(interns.ID, staff.ID),
+		P.AssignRoleToGroup(staff.ID, seedAdminRoleID),
+		P.AssignParentToRole(manager.ID, seedAdminRoleID),
+		P.AssignRoleToEntity("manager", manager.ID, Resource{}),
+		P.AssignRoleToEntity("scoped", seedAdminRoleID, Resource{Type: "project", ID: "42"}),
+		P.AssignPermissionToRole(analyst.ID, reports[0].ID),
+		P.AssignRoleToEntity("analyst", analyst.ID, Resource{}),
+		P.DenyPermissionToEntity("member", seedDeleteID),
+	} {
+		if step != nil {
+			t.Fatal(step)
+		}
+	}
+
+	var cases = []struct {
+		Name     string
+		List     func(opts ListOptions) (EntityPage, error)
+		Expected []string
+	}{
+		{"admin role", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByRoleID(seedAdminRoleID, opts)
+		}, []string{seedAdminEntity, "member", "nested member", "scoped"}},
+		{"manager role", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByRoleID(manager.ID, opts)
+		}, []string{"manager"}},
+		{"read", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByPermissionID(seedReadID, opts)
+		}, []string{seedCustomerEntity, seedAdminEntity, "manager", "member", "nested member"}},
+		{"delete", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByPermissionID(seedDeleteID, opts)
+		}, []string{seedAdminEntity, "manager", "nested member"}},
+		{"delete by name", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByPermissionName("TacoApp", "delete", opts)
+		}, []string{seedAdminEntity, "manager", "nested member"}},
+		{"wildcard", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByPermissionID(reports[1].ID, opts)
+		}, []string{"analyst"}},
+	}
+	for _, tc := range cases {
+		var entityIDs []string
+		opts := ListOptions{PageSize: 2}
+		for {
+			page, err := tc.List(opts)
+			if err != nil {
+				t.Fatalf("[%s] %v", tc.Name, err)
+			}
+			if len(page.EntityIDs) > 2 {
+				t.Errorf("[%s] Expected at most 2 entities a page got %v", tc.Name, page.EntityIDs)
+			}
+			entityIDs = append(entityIDs, page.EntityIDs...)
+			if opts.PageToken = page.NextPageToken; opts.PageToken == "" {
+				break
+			}
+		}
+		if fmt.Sprint(entityIDs) != fmt.Sprint(tc.Expected) {
+			t.Errorf("[%s] Expected entities %v got %v", tc.Name, tc.Expected, entityIDs)
+		}
+	}
+
+	page, err := P.ListEntitiesByPermissionID(seedReadID, ListOptions{Prefix: "m", OrderBy: "-id"})
+	if err != nil || fmt.Sprint(page.EntityIDs) != "[member manager]" {
+		t.Errorf("Expected entities [member manager] got %v [%v]", page.EntityIDs, err)
+	}
+	if _, err := P.ListEntitiesByRoleID(missingID, ListOptions{}); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound listing the entities of a missing role")
+	}
+	if _, err := P.ListEntitiesByPermissionID(missingID, ListOptions{}); errors.Cause(err) != ErrNotFound {
+		t.Error("Expected ErrNotFound listing the entities of a missing permission")
+	}
+}
+
+func conformManyHolders(t *testing.T, P *Permissionist) {
+	viewer, err := P.CreateRole("viewer", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	view, err := P.CreatePermission("view", seedAppID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := P.AssignPermissionToRole(viewer.ID, view.ID); err != nil {
+		t.Fatal(err)
+	}
+	// more holders than one chunk of grant checks, with a denial in every
+	// chunk
+	var holders, allowed []string
+	for i := 0; i < 2*holderChunkSize+1; i++ {
+		entityID := fmt.Sprintf("viewer %04d", i)
+		if err := P.AssignRoleToEntity(entityID, viewer.ID, Resource{}); err != nil {
+			t.Fatal(err)
+		}
+		holders = append(holders, entityID)
+		if i%holderChunkSize == 0 {
+			if err := P.DenyPermissionToEntity(entityID, view.ID); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		allowed = append(allowed, entityID)
+	}
+
+	var cases = []struct {
+		Name     string
+		List     func(opts ListOptions) (EntityPage, error)
+		Expected []string
+	}{
+		{"viewer role", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByRoleID(viewer.ID, opts)
+		}, holders},
+		{"view", func(opts ListOptions) (EntityPage, error) {
+			return P.ListEntitiesByPermissionID(view.ID, opts)
+		}, allowed},
+	}
+	for _, tc := range cases {
+		var entityIDs []string
+		opts := ListOptions{PageSize: 100}
+		for {
+			page, err := tc.List(opts)
+			if err != nil {
+				t.Fatalf("[%s] %v", tc.Name, err)
+			}
+			entityIDs = append(entityIDs, page.EntityIDs...)
+			if opts.PageToken = page.NextPageToken; opts.PageToken == "" {
+				break
+			}
+		}
+		if fmt.Sprint(entityIDs) != fmt.Sprint(tc.Expected) {
+			t.Errorf("[%s] Expected %d entities got %d", tc.Name, len(tc.Expected), len(entityIDs))
+		}
+	}
+}
+
+// indexOf returns the index of value in values, or -1
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func testCleanup(db *sqlx.DB) {
+	_, err := db.Exec(`
+		DROP TABLE IF EXISTS apps CASCADE;
+		DROP TABLE IF EXISTS permissions CASCADE;
+		DROP TABLE IF EXISTS role_permissions CASCADE;
+		DROP TABLE IF EXISTS role_parents CASCADE;
+		DROP TABLE IF EXISTS roles CASCADE;
+		DROP TABLE IF EXISTS entity_roles CASCADE;
+		DROP TABLE IF EXISTS entity_denials CASCADE;
+		DROP TABLE IF EXISTS entity_groups CASCADE;
+		DROP TABLE IF EXISTS group_members CASCADE;
+		DROP TABLE IF EXISTS group_parents CASCADE;
+		DROP TABLE IF EXISTS group_roles CASCADE;
+		DROP TABLE IF EXISTS relation_tuples CASCADE;
+		DROP TABLE IF EXISTS relation_namespaces CASCADE;
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func testConfig() *viper.Viper {
+	config := viper.New()
+	config.SetConfigFile(os.Getenv("CONFIG"))
+	err := config.ReadInConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config
+}
+
+func testDb(database string) *sqlx.DB {
+	db, err := sqlx.Connect("postgres", database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
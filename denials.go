@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// DenyPermissionToRole denies permission to role. The denial overrides any
+// grant of the permission, whether made to the role itself or inherited,
+// and applies to every role that inherits from role and every entity
+// holding one of them.
+func (permissions *Permissionist) DenyPermissionToRole(roleID string, permissionID string) error {
+	err := permissions.Store.InsertRolePermission(RolePermission{
+		ID:           uuid.NewV4().String(),
+		RoledID:      roleID,
+		PermissionID: permissionID,
+		Deny:         true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not deny permission to role")
+	}
+
+	return nil
+}
+
+// RemoveRoleDenial removes role's denial of permission
+func (permissions *Permissionist) RemoveRoleDenial(roleID string, permissionID string) error {
+	err := permissions.Store.DeleteRolePermission(roleID, permissionID, true)
+	if err != nil {
+		return errors.Wrap(err, "Could not remove role denial")
+	}
+
+	return nil
+}
+
+// GetDeniedPermissionsByRoleID returns the permissions denied directly to a role
+func (permissions *Permissionist) GetDeniedPermissionsByRoleID(roleID string) ([]Permission, error) {
+	perms, err := permissions.Store.GetPermissionsByRoleID(roleID, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get denied permissions")
+	}
+
+	return perms, nil
+}
+
+// DenyPermissionToEntity denies permission to entity regardless of the
+// roles it holds
+func (permissions *Permissionist) DenyPermissionToEntity(entityID string, permissionID string) error {
+	err := permissions.Store.InsertEntityDenial(EntityDenial{
+		ID:           uuid.NewV4().String(),
+		EntityID:     entityID,
+		PermissionID: permissionID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not deny permission to entity")
+	}
+
+	return nil
+}
+
+// RemoveEntityDenial removes entity's denial of permission
+func (permissions *Permissionist) RemoveEntityDenial(entityID string, permissionID string) error {
+	err := permissions.Store.DeleteEntityDenial(entityID, permissionID)
+	if err != nil {
+		return errors.Wrap(err, "Could not remove entity denial")
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Error codes, the machine-readable kind of an error sent to clients in the
+// code field of an error response
+const (
+	CodeNotFound        = "not_found"
+	CodeConflict        = "conflict"
+	CodeInvalidArgument = "invalid_argument"
+	CodeInternal        = "internal"
+)
+
+// Errors returned for bad arguments rather than by a store
+var (
+	ErrMissingField   = errors.New("Missing required field")
+	ErrInvalidRequest = errors.New("Could not process request")
+)
+
+// errorClass is the code and the more specific reason of a known error
+type errorClass struct {
+	code   string
+	reason string
+}
+
+// errorClasses classifies the errors Permissionist returns. Anything else is
+// internal.
+var errorClasses = map[error]errorClass{
+	ErrNotFound:         {CodeNotFound, "not_found"},
+	ErrMissingReference: {CodeNotFound, "missing_reference"},
+	ErrDuplicate:        {CodeConflict, "duplicate"},
+	ErrRoleCycle:        {CodeConflict, "role_cycle"},
+	ErrGroupCycle:       {CodeConflict, "group_cycle"},
+	ErrInvalidID:        {CodeInvalidArgument, "invalid_id"},
+	ErrAppMismatch:      {CodeInvalidArgument, "app_mismatch"},
+	ErrInvalidResource:  {CodeInvalidArgument, "invalid_resource"},
+	ErrInvalidValidity:  {CodeInvalidArgument, "invalid_validity"},
+	ErrInvalidCondition: {CodeInvalidArgument, "invalid_condition"},
+	ErrInvalidNamespace: {CodeInvalidArgument, "invalid_namespace"},
+	ErrInvalidTuple:     {CodeInvalidArgument, "invalid_tuple"},
+	ErrRelationDepth:    {CodeInvalidArgument, "relation_depth"},
+	ErrInvalidPolicy:    {CodeInvalidArgument, "invalid_policy"},
+	ErrInvalidExport:    {CodeInvalidArgument, "invalid_export"},
+	ErrInvalidBatch:     {CodeInvalidArgument, "invalid_batch"},
+	ErrMissingField:     {CodeInvalidArgument, "missing_field"},
+	ErrInvalidRequest:   {CodeInvalidArgument, "invalid_request"},
+	ErrRequestTooLarge:  {CodeInvalidArgument, "request_too_large"},
+	ErrInvalidPageToken: {CodeInvalidArgument, "invalid_page_token"},
+	ErrInvalidOrder:     {CodeInvalidArgument, "invalid_order"},
+}
+
+// codeStatuses maps each error code to its response status
+var codeStatuses = map[string]int{
+	CodeNotFound:        404,
+	CodeConflict:        409,
+	CodeInvalidArgument: 400,
+	CodeInternal:        500,
+}
+
+// ErrorCode returns the code of an error from Permissionist: CodeNotFound,
+// CodeConflict, CodeInvalidArgument, or CodeInternal for anything else
+func ErrorCode(err error) string {
+	if class, ok := errorClasses[errors.Cause(err)]; ok {
+		return class.code
+	}
+	return CodeInternal
+}
+
+// IsNotFound checks if err is about a record that does not exist
+func IsNotFound(err error) bool {
+	return ErrorCode(err) == CodeNotFound
+}
+
+// IsConflict checks if err is about a change clashing with existing records
+func IsConflict(err error) bool {
+	return ErrorCode(err) == CodeConflict
+}
+
+// IsInvalidArgument checks if err is about an argument that can never succeed
+func IsInvalidArgument(err error) bool {
+	return ErrorCode(err) == CodeInvalidArgument
+}
+
+// ErrorResponse is the body of every error response. Reason and Detail
+// narrow Code down, and are left out for internal errors. Fields lists the
+// invalid fields of a request that failed validation.
+type ErrorResponse struct {
+	Code    string       `json:"code"`
+	Reason  string       `json:"reason,omitempty"`
+	Message string       `json:"message"`
+	Detail  string       `json:"detail,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// errorResponse describes err to a client, with message saying what failed
+func errorResponse(err error, message string) ErrorResponse {
+	response := ErrorResponse{Code: CodeInternal, Message: message}
+	if class, ok := errorClasses[errors.Cause(err)]; ok {
+		response.Code = class.code
+		response.Reason = class.reason
+		response.Detail = err.Error()
+		response.Fields = validationFields(err)
+	}
+	return response
+}
+
+// writeError responds with the status and ErrorResponse for err
+func writeError(w http.ResponseWriter, err error, message string) {
+	response := errorResponse(err, message)
+	bytes, _ := json.Marshal(&response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(codeStatuses[response.Code])
+	w.Write(bytes)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// ErrInvalidValidity is returned for a validity that expires before it
+// starts
+var ErrInvalidValidity = errors.New("Grant expires before it starts")
+
+// Validity bounds when a role assignment or a role permission is in force.
+// A nil NotBefore means it is in force from the start, a nil ExpiresAt that
+// it never expires.
+type Validity struct {
+	NotBefore *time.Time `json:"not_before,omitempty" db:"not_before"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+}
+
+// activeAt checks if validity is in force at t
+func (validity Validity) activeAt(t time.Time) bool {
+	if validity.NotBefore != nil && t.Before(*validity.NotBefore) {
+		return false
+	}
+	if validity.ExpiresAt != nil && !t.Before(*validity.ExpiresAt) {
+		return false
+	}
+	return true
+}
+
+// check validates validity and returns it in UTC, which keeps times stored
+// as text comparable
+func (validity Validity) check() (Validity, error) {
+	if validity.NotBefore != nil && validity.ExpiresAt != nil && !validity.NotBefore.Before(*validity.ExpiresAt) {
+		return validity, ErrInvalidValidity
+	}
+	if validity.NotBefore != nil {
+		notBefore := validity.NotBefore.UTC()
+		validity.NotBefore = &notBefore
+	}
+	if validity.ExpiresAt != nil {
+		expiresAt := validity.ExpiresAt.UTC()
+		validity.ExpiresAt = &expiresAt
+	}
+	return validity, nil
+}
+
+// validityFromQuery reads the not_before and expires_at query parameters,
+// both RFC 3339 times
+func validityFromQuery(r *http.Request) (Validity, error) {
+	var validity Validity
+	for name, field := range map[string]**time.Time{
+		"not_before": &validity.NotBefore,
+		"expires_at": &validity.ExpiresAt,
+	} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return validity, errors.Wrapf(ErrInvalidValidity, "Could not parse %s", name)
+		}
+		*field = &t
+	}
+	return validity, nil
+}
+
+// AssignTemporaryRoleToEntity assigns role to entity on resource for the
+// period validity. Checks ignore the assignment outside that period.
+func (permissions *Permissionist) AssignTemporaryRoleToEntity(entityID string, roleID string, resource Resource, validity Validity) error {
+	if err := resource.check(); err != nil {
+		return errors.Wrap(err, "Could not assign role to entity")
+	}
+	validity, err := validity.check()
+	if err != nil {
+		return errors.Wrap(err, "Could not assign role to entity")
+	}
+	err = permissions.Store.InsertEntityRole(EntityRole{
+		ID:       uuid.NewV4().String(),
+		EntityID: entityID,
+		RoleID:   roleID,
+		Resource: resource,
+		Validity: validity,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not assign role to entity")
+	}
+
+	return nil
+}
+
+// AssignTemporaryPermissionToRole assigns permission to role for the period
+// validity. Checks ignore the grant outside that period.
+func (permissions *Permissionist) AssignTemporaryPermissionToRole(roleID string, permissionID string, validity Validity) error {
+	return permissions.grantPermissionToRole(roleID, permissionID, "", validity)
+}
+
+// SweepReport lists the grants removed by a sweep
+type SweepReport struct {
+	EntityRoles     []EntityRole     `json:"entity_roles"`
+	RolePermissions []RolePermission `json:"role_permissions"`
+}
+
+// SweepExpired removes every role assignment and role permission that
+// expired at or before now
+func (permissions *Permissionist) SweepExpired(now time.Time) (SweepReport, error) {
+	var report SweepReport
+	entityRoles, err := permissions.Store.DeleteExpiredEntityRoles(now.UTC())
+	if err != nil {
+		return report, errors.Wrap(err, "Could not sweep expired role assignments")
+	}
+	report.EntityRoles = entityRoles
+	rolePermissions, err := permissions.Store.DeleteExpiredRolePermissions(now.UTC())
+	if err != nil {
+		return report, errors.Wrap(err, "Could not sweep expired role permissions")
+	}
+	report.RolePermissions = rolePermissions
+
+	return report, nil
+}
+
+// Sweeper runs SweepExpired in the background every interval and hands each
+// result to report
+type Sweeper struct {
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// StartSweeper starts a Sweeper for permissions. interval must be positive.
+func (permissions *Permissionist) StartSweeper(interval time.Duration, report func(SweepReport, error)) *Sweeper {
+	sweeper := &Sweeper{stop: make(chan struct{})}
+	sweeper.done.Add(1)
+	go func() {
+		defer sweeper.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				report(permissions.SweepExpired(now))
+			case <-sweeper.stop:
+				return
+			}
+		}
+	}()
+	return sweeper
+}
+
+// Stop stops the sweeper and waits for a sweep in progress to finish
+func (sweeper *Sweeper) Stop() {
+	close(sweeper.stop)
+	sweeper.done.Wait()
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidExport is returned for an export document that does not parse
+// or is not self-contained
+var ErrInvalidExport = errors.New("Invalid export")
+
+// AppExport is a copy of one app's roles, permissions, role inheritance and
+// role permissions, and optionally what its entities hold: their role
+// assignments and denials, and the groups with their members, nesting and
+// roles. Records keep their ids, so clients referring to them keep working
+// after ImportApp restores the copy into another instance.
+type AppExport struct {
+	App             App              `json:"app"`
+	Permissions     []Permission     `json:"permissions"`
+	Roles           []Role           `json:"roles"`
+	RoleParents     []RoleParent     `json:"role_parents"`
+	RolePermissions []RolePermission `json:"role_permissions"`
+	EntityRoles     []EntityRole     `json:"entity_roles,omitempty"`
+	EntityDenials   []EntityDenial   `json:"entity_denials,omitempty"`
+	Groups          []Group          `json:"groups,omitempty"`
+	GroupMembers    []GroupMember    `json:"group_members,omitempty"`
+	GroupParents    []GroupParent    `json:"group_parents,omitempty"`
+	GroupRoles      []GroupRole      `json:"group_roles,omitempty"`
+}
+
+// ExportApp copies app appID, including what its entities hold when
+// withEntityRoles is set
+func (permissions *Permissionist) ExportApp(appID string, withEntityRoles bool) (AppExport, error) {
+	export, err := permissions.exportApp(appID, withEntityRoles)
+	if err != nil {
+		return export, errors.Wrap(err, "Could not export app")
+	}
+
+	return export, nil
+}
+
+func (permissions *Permissionist) exportApp(appID string, withEntityRoles bool) (AppExport, error) {
+	var export AppExport
+	var err error
+	if export.App, err = permissions.Store.GetApp(appID); err != nil {
+		return export, err
+	}
+	if export.Permissions, err = permissions.Store.GetPermissionsByAppID(appID); err != nil {
+		return export, err
+	}
+	if export.Roles, err = permissions.Store.GetRolesByAppID(appID); err != nil {
+		return export, err
+	}
+	var roleIDs []string
+	for _, role := range export.Roles {
+		roleIDs = append(roleIDs, role.ID)
+	}
+	if export.RoleParents, err = permissions.Store.GetRoleParents(roleIDs); err != nil {
+		return export, err
+	}
+	if export.RolePermissions, err = permissions.Store.GetRolePermissions(roleIDs); err != nil {
+		return export, err
+	}
+	sort.Slice(export.RoleParents, func(i, j int) bool { return export.RoleParents[i].ID < export.RoleParents[j].ID })
+	sort.Slice(export.RolePermissions, func(i, j int) bool { return export.RolePermissions[i].ID < export.RolePermissions[j].ID })
+	if withEntityRoles {
+		return export, permissions.exportEntities(&export, roleIDs)
+	}
+	return export, nil
+}
+
+// exportEntities adds what the entities of the app in export hold through
+// roles roleIDs, denials and groups
+func (permissions *Permissionist) exportEntities(export *AppExport, roleIDs []string) error {
+	var err error
+	if export.EntityRoles, err = permissions.Store.GetEntityRolesByRoleIDs(roleIDs); err != nil {
+		return err
+	}
+	var permissionIDs []string
+	for _, perm := range export.Permissions {
+		permissionIDs = append(permissionIDs, perm.ID)
+	}
+	if export.EntityDenials, err = permissions.Store.GetEntityDenialsByPermissionIDs(permissionIDs); err != nil {
+		return err
+	}
+	if export.Groups, err = permissions.Store.GetGroupsByAppID(export.App.ID); err != nil {
+		return err
+	}
+	var groupIDs []string
+	for _, group := range export.Groups {
+		groupIDs = append(groupIDs, group.ID)
+		members, err := permissions.Store.GetGroupMembers(group.ID)
+		if err != nil {
+			return err
+		}
+		export.GroupMembers = append(export.GroupMembers, members...)
+	}
+	if len(groupIDs) > 0 {
+		if export.GroupParents, err = permissions.Store.GetGroupParents(groupIDs); err != nil {
+			return err
+		}
+		if export.GroupRoles, err = permissions.Store.GetGroupRoles(groupIDs); err != nil {
+			return err
+		}
+	}
+	sort.Slice(export.EntityRoles, func(i, j int) bool { return export.EntityRoles[i].ID < export.EntityRoles[j].ID })
+	sort.Slice(export.EntityDenials, func(i, j int) bool { return export.EntityDenials[i].ID < export.EntityDenials[j].ID })
+	sort.Slice(export.Groups, func(i, j int) bool { return export.Groups[i].ID < export.Groups[j].ID })
+	sort.Slice(export.GroupMembers, func(i, j int) bool { return export.GroupMembers[i].ID < export.GroupMembers[j].ID })
+	sort.Slice(export.GroupParents, func(i, j int) bool { return export.GroupParents[i].ID < export.GroupParents[j].ID })
+	sort.Slice(export.GroupRoles, func(i, j int) bool { return export.GroupRoles[i].ID < export.GroupRoles[j].ID })
+	return nil
+}
+
+// ImportApp creates the app in export along with everything it holds, in a
+// single transaction. It fails if the app or any record already exists.
+func (permissions *Permissionist) ImportApp(export AppExport) (App, error) {
+	if err := export.check(); err != nil {
+		return App{}, errors.Wrap(err, "Could not import app")
+	}
+	err := permissions.Store.Transact(func(store Store) error {
+		if err := store.InsertApp(export.App); err != nil {
+			return err
+		}
+		if len(export.Permissions) > 0 {
+			if _, err := store.InsertPermissions(export.Permissions, InsertStrict); err != nil {
+				return err
+			}
+		}
+		if len(export.Roles) > 0 {
+			if _, err := store.InsertRoles(export.Roles, InsertStrict); err != nil {
+				return err
+			}
+		}
+		for _, rp := range export.RoleParents {
+			if err := store.InsertRoleParent(rp); err != nil {
+				return err
+			}
+		}
+		for _, rp := range export.RolePermissions {
+			if err := store.InsertRolePermission(rp); err != nil {
+				return err
+			}
+		}
+		for _, er := range export.EntityRoles {
+			if err := store.InsertEntityRole(er); err != nil {
+				return err
+			}
+		}
+		for _, ed := range export.EntityDenials {
+			if err := store.InsertEntityDenial(ed); err != nil {
+				return err
+			}
+		}
+		for _, group := range export.Groups {
+			if err := store.InsertGroup(group); err != nil {
+				return err
+			}
+		}
+		for _, gm := range export.GroupMembers {
+			if err := store.InsertGroupMember(gm); err != nil {
+				return err
+			}
+		}
+		for _, gp := range export.GroupParents {
+			if err := store.InsertGroupParent(gp); err != nil {
+				return err
+			}
+		}
+		for _, gr := range export.GroupRoles {
+			if err := store.InsertGroupRole(gr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return App{}, errors.Wrap(err, "Could not import app")
+	}
+
+	return export.App, nil
+}
+
+// check validates that every record belongs to the exported app and only
+// references records in the export
+func (export *AppExport) check() error {
+	if export.App.ID == "" || export.App.Name == "" {
+		return errors.Wrap(ErrInvalidExport, "Missing app")
+	}
+	permissionIDs := map[string]bool{}
+	for _, perm := range export.Permissions {
+		if perm.AppID != export.App.ID {
+			return errors.Wrapf(ErrAppMismatch, "Permission %s", perm.ID)
+		}
+		permissionIDs[perm.ID] = true
+	}
+	roleIDs := map[string]bool{}
+	for _, role := range export.Roles {
+		if role.AppID != export.App.ID {
+			return errors.Wrapf(ErrAppMismatch, "Role %s", role.ID)
+		}
+		roleIDs[role.ID] = true
+	}
+	for _, rp := range export.RoleParents {
+		if !roleIDs[rp.RoleID] || !roleIDs[rp.ParentID] {
+			return errors.Wrapf(ErrInvalidExport, "Role parent %s references a role outside the app", rp.ID)
+		}
+	}
+	for i, rp := range export.RolePermissions {
+		if !roleIDs[rp.RoledID] || !permissionIDs[rp.PermissionID] {
+			return errors.Wrapf(ErrInvalidExport, "Role permission %s references a record outside the app", rp.ID)
+		}
+		if rp.Condition != "" {
+			if _, err := parseCondition(rp.Condition); err != nil {
+				return err
+			}
+		}
+		validity, err := rp.Validity.check()
+		if err != nil {
+			return err
+		}
+		export.RolePermissions[i].Validity = validity
+	}
+	for i, er := range export.EntityRoles {
+		if !roleIDs[er.RoleID] {
+			return errors.Wrapf(ErrInvalidExport, "Entity role %s references a role outside the app", er.ID)
+		}
+		if err := er.Resource.check(); err != nil {
+			return err
+		}
+		validity, err := er.Validity.check()
+		if err != nil {
+			return err
+		}
+		export.EntityRoles[i].Validity = validity
+	}
+	for _, ed := range export.EntityDenials {
+		if !permissionIDs[ed.PermissionID] {
+			return errors.Wrapf(ErrInvalidExport, "Entity denial %s references a permission outside the app", ed.ID)
+		}
+	}
+	groupIDs := map[string]bool{}
+	for _, group := range export.Groups {
+		if group.AppID != export.App.ID {
+			return errors.Wrapf(ErrAppMismatch, "Group %s", group.ID)
+		}
+		groupIDs[group.ID] = true
+	}
+	for _, gm := range export.GroupMembers {
+		if !groupIDs[gm.GroupID] {
+			return errors.Wrapf(ErrInvalidExport, "Group member %s references a group outside the app", gm.ID)
+		}
+	}
+	for _, gp := range export.GroupParents {
+		if !groupIDs[gp.GroupID] || !groupIDs[gp.ParentID] {
+			return errors.Wrapf(ErrInvalidExport, "Group parent %s references a group outside the app", gp.ID)
+		}
+	}
+	for _, gr := range export.GroupRoles {
+		if !groupIDs[gr.GroupID] || !roleIDs[gr.RoleID] {
+			return errors.Wrapf(ErrInvalidExport, "Group role %s references a record outside the app", gr.ID)
+		}
+	}
+	return nil
+}
+
+// MarshalExport encodes export as "json" or "yaml"
+func MarshalExport(export AppExport, format string) ([]byte, error) {
+	document, err := json.MarshalIndent(&export, "", "  ")
+	if err != nil || format == "json" {
+		return document, err
+	}
+	if format != "yaml" {
+		return nil, errors.Wrapf(ErrInvalidExport, "Unknown format %q", format)
+	}
+	// JSON is YAML, and a MapSlice keeps the keys in order
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(document, &tree); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(tree)
+}
+
+// UnmarshalExport decodes an export document in JSON or YAML
+func UnmarshalExport(document []byte) (AppExport, error) {
+	var export AppExport
+	var tree interface{}
+	if err := yaml.Unmarshal(document, &tree); err != nil {
+		return export, errors.Wrap(ErrInvalidExport, err.Error())
+	}
+	document, err := json.Marshal(jsonValue(tree))
+	if err != nil {
+		return export, errors.Wrap(ErrInvalidExport, err.Error())
+	}
+	if err := json.Unmarshal(document, &export); err != nil {
+		return export, errors.Wrap(ErrInvalidExport, err.Error())
+	}
+	return export, nil
+}
+
+// jsonValue converts the maps yaml decodes into ones encoding/json accepts
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		object := map[string]interface{}{}
+		for key, item := range v {
+			object[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonValue(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,178 @@
+package main
+
+import "time"
+
+// grantSet holds the permission ids a role or entity is granted and denied.
+// A denial always wins over a grant.
+type grantSet struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newGrantSet() grantSet {
+	return grantSet{allow: map[string]bool{}, deny: map[string]bool{}}
+}
+
+// allows checks if perm is granted and not denied. index maps permission
+// id to permission for every id in grants, so that grants and denials of
+// wildcard permissions can be matched against perm's name.
+func (grants grantSet) allows(perm Permission, index map[string]Permission) bool {
+	return covers(grants.allow, perm, index) && !covers(grants.deny, perm, index)
+}
+
+// covers checks if any of permissionIDs is perm or a wildcard permission of
+// the same app matching perm's name
+func covers(permissionIDs map[string]bool, perm Permission, index map[string]Permission) bool {
+	if perm.ID == "" {
+		return false
+	}
+	if permissionIDs[perm.ID] {
+		return true
+	}
+	for permissionID := range permissionIDs {
+		pattern, ok := index[permissionID]
+		if ok && pattern.AppID == perm.AppID && permissionNameMatches(pattern.Name, perm.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// merge adds the grants and denials in other
+func (grants grantSet) merge(other grantSet) {
+	for permissionID := range other.allow {
+		grants.allow[permissionID] = true
+	}
+	for permissionID := range other.deny {
+		grants.deny[permissionID] = true
+	}
+}
+
+// entityGrants resolves, for each of entityIDs, what it holds on resource
+// through its roles, the roles of its groups and their ancestors, plus any
+// denials made directly to it. Unscoped role assignments apply to every
+// resource, as do group roles. Assignments and role permissions out of
+// their validity are ignored, as are role permissions whose condition does
+// not hold in context.
+func (permissions *Permissionist) entityGrants(entityIDs []string, resource Resource, context Context) (map[string]grantSet, error) {
+	assignments, err := permissions.Store.GetEntityRoles(entityIDs)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var entityRoles []EntityRole
+	for _, er := range assignments {
+		if !er.activeAt(now) {
+			continue
+		}
+		if er.Resource == (Resource{}) || er.Resource == resource {
+			entityRoles = append(entityRoles, er)
+		}
+	}
+	groupRoles, err := permissions.groupRoles(entityIDs)
+	if err != nil {
+		return nil, err
+	}
+	for entityID, roleIDs := range groupRoles {
+		for _, roleID := range roleIDs {
+			entityRoles = append(entityRoles, EntityRole{EntityID: entityID, RoleID: roleID})
+		}
+	}
+	entityDenials, err := permissions.Store.GetEntityDenials(entityIDs)
+	if err != nil {
+		return nil, err
+	}
+	var roleIDs []string
+	for _, er := range entityRoles {
+		roleIDs = append(roleIDs, er.RoleID)
+	}
+	roles, err := permissions.roleGrants(roleIDs, context)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := map[string]grantSet{}
+	for _, entityID := range entityIDs {
+		grants[entityID] = newGrantSet()
+	}
+	for _, er := range entityRoles {
+		grants[er.EntityID].merge(roles[er.RoleID])
+	}
+	for _, ed := range entityDenials {
+		grants[ed.EntityID].deny[ed.PermissionID] = true
+	}
+	return grants, nil
+}
+
+// roleGrants resolves, for each of roleIDs, what it holds directly or
+// through its ancestors in context. A denial on any ancestor applies to the
+// role.
+func (permissions *Permissionist) roleGrants(roleIDs []string, context Context) (map[string]grantSet, error) {
+	graph, err := permissions.roleGraph(roleIDs)
+	if err != nil {
+		return nil, err
+	}
+	var allRoleIDs []string
+	for roleID := range graph {
+		allRoleIDs = append(allRoleIDs, roleID)
+	}
+	rolePermissions, err := permissions.Store.GetRolePermissions(allRoleIDs)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	direct := map[string]grantSet{}
+	for _, rp := range rolePermissions {
+		// checks without a context never count conditional grants
+		if rp.Condition != "" && context == nil {
+			continue
+		}
+		if !rp.activeAt(now) || !evalCondition(rp.Condition, context) {
+			continue
+		}
+		if _, ok := direct[rp.RoledID]; !ok {
+			direct[rp.RoledID] = newGrantSet()
+		}
+		if rp.Deny {
+			direct[rp.RoledID].deny[rp.PermissionID] = true
+		} else {
+			direct[rp.RoledID].allow[rp.PermissionID] = true
+		}
+	}
+
+	grants := map[string]grantSet{}
+	for _, roleID := range roleIDs {
+		grants[roleID] = newGrantSet()
+		for _, ancestorID := range graph.ancestors(roleID) {
+			grants[roleID].merge(direct[ancestorID])
+		}
+	}
+	return grants, nil
+}
+
+// permissionIndex loads every permission in grants and permissionIDs, keyed
+// by id
+func (permissions *Permissionist) permissionIndex(grants map[string]grantSet, permissionIDs []string) (map[string]Permission, error) {
+	wanted := stringSet(permissionIDs)
+	for _, set := range grants {
+		for permissionID := range set.allow {
+			wanted[permissionID] = true
+		}
+		for permissionID := range set.deny {
+			wanted[permissionID] = true
+		}
+	}
+	var ids []string
+	for permissionID := range wanted {
+		ids = append(ids, permissionID)
+	}
+	perms, err := permissions.Store.GetPermissions(ids)
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]Permission{}
+	for _, perm := range perms {
+		index[perm.ID] = perm
+	}
+	return index, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// ErrGroupCycle is returned when nesting a group would make it a member of
+// itself
+var ErrGroupCycle = errors.New("Group nesting would create a cycle")
+
+// Group entity_groups schema. Members of a group hold every role assigned
+// to it or to a group it is nested in.
+type Group struct {
+	ID    string `json:"id" db:"id"`
+	Name  string `json:"name" db:"name"`
+	AppID string `json:"app_id" db:"app_id"`
+}
+
+// CreateGroup creates a new group in an app
+func (permissions *Permissionist) CreateGroup(groupName string, appID string) (Group, error) {
+	group := Group{
+		ID:    uuid.NewV4().String(),
+		Name:  groupName,
+		AppID: appID,
+	}
+	if err := permissions.Store.InsertGroup(group); err != nil {
+		return Group{}, errors.Wrap(err, "Could not create group")
+	}
+
+	return group, nil
+}
+
+// GetGroup returns a group by id
+func (permissions *Permissionist) GetGroup(groupID string) (Group, error) {
+	group, err := permissions.Store.GetGroup(groupID)
+	if err != nil {
+		return group, errors.Wrap(err, "Could not get group")
+	}
+
+	return group, nil
+}
+
+// GetGroupsByAppID returns a list of all groups created for an app
+func (permissions *Permissionist) GetGroupsByAppID(appID string) ([]Group, error) {
+	groups, err := permissions.Store.GetGroupsByAppID(appID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get groups")
+	}
+
+	return groups, nil
+}
+
+// RemoveGroup removes a group along with its memberships and role assignments
+func (permissions *Permissionist) RemoveGroup(groupID string) error {
+	if err := permissions.Store.DeleteGroup(groupID); err != nil {
+		return errors.Wrap(err, "Could not remove group")
+	}
+
+	return nil
+}
+
+// AddEntityToGroup makes entity entityID a member of group groupID
+func (permissions *Permissionist) AddEntityToGroup(groupID string, entityID string) error {
+	err := permissions.Store.InsertGroupMember(GroupMember{
+		ID:       uuid.NewV4().String(),
+		GroupID:  groupID,
+		EntityID: entityID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not add entity to group")
+	}
+
+	return nil
+}
+
+// RemoveEntityFromGroup removes entity entityID from group groupID
+func (permissions *Permissionist) RemoveEntityFromGroup(groupID string, entityID string) error {
+	if err := permissions.Store.DeleteGroupMember(groupID, entityID); err != nil {
+		return errors.Wrap(err, "Could not remove entity from group")
+	}
+
+	return nil
+}
+
+// GetGroupMembers returns the ids of the entities added directly to a group
+func (permissions *Permissionist) GetGroupMembers(groupID string) ([]string, error) {
+	members, err := permissions.Store.GetGroupMembers(groupID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get group members")
+	}
+
+	entityIDs := []string{}
+	for _, member := range members {
+		entityIDs = append(entityIDs, member.EntityID)
+	}
+	return entityIDs, nil
+}
+
+// NestGroup makes group groupID a member of group parentID, so its members
+// hold parentID's roles too. Both groups must belong to the same app, and
+// the nesting is refused if parentID is already nested in groupID.
+func (permissions *Permissionist) NestGroup(groupID string, parentID string) error {
+	group, err := permissions.Store.GetGroup(groupID)
+	if err != nil {
+		return errors.Wrap(err, "Could not get group")
+	}
+	parent, err := permissions.Store.GetGroup(parentID)
+	if err != nil {
+		return errors.Wrap(err, "Could not get parent group")
+	}
+	if group.AppID != parent.AppID {
+		return ErrAppMismatch
+	}
+
+	graph, err := permissions.groupGraph([]string{parentID})
+	if err != nil {
+		return errors.Wrap(err, "Could not get group parents")
+	}
+	for _, ancestorID := range graph.ancestors(parentID) {
+		if ancestorID == groupID {
+			return ErrGroupCycle
+		}
+	}
+
+	err = permissions.Store.InsertGroupParent(GroupParent{
+		ID:       uuid.NewV4().String(),
+		GroupID:  groupID,
+		ParentID: parentID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not nest group")
+	}
+
+	return nil
+}
+
+// UnnestGroup stops group groupID being a member of group parentID
+func (permissions *Permissionist) UnnestGroup(groupID string, parentID string) error {
+	if err := permissions.Store.DeleteGroupParent(groupID, parentID); err != nil {
+		return errors.Wrap(err, "Could not unnest group")
+	}
+
+	return nil
+}
+
+// AssignRoleToGroup assigns role roleID to every member of group groupID.
+// The role and group must belong to the same app.
+func (permissions *Permissionist) AssignRoleToGroup(groupID string, roleID string) error {
+	group, err := permissions.Store.GetGroup(groupID)
+	if err != nil {
+		return errors.Wrap(err, "Could not get group")
+	}
+	role, err := permissions.Store.GetRole(roleID)
+	if err != nil {
+		return errors.Wrap(err, "Could not get role")
+	}
+	if group.AppID != role.AppID {
+		return ErrAppMismatch
+	}
+
+	err = permissions.Store.InsertGroupRole(GroupRole{
+		ID:      uuid.NewV4().String(),
+		GroupID: groupID,
+		RoleID:  roleID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not assign role to group")
+	}
+
+	return nil
+}
+
+// UnassignRoleFromGroup unassigns role roleID from group groupID
+func (permissions *Permissionist) UnassignRoleFromGroup(groupID string, roleID string) error {
+	if err := permissions.Store.DeleteGroupRole(groupID, roleID); err != nil {
+		return errors.Wrap(err, "Could not unassign role from group")
+	}
+
+	return nil
+}
+
+// GetRolesByGroupID returns the roles assigned directly to a group
+func (permissions *Permissionist) GetRolesByGroupID(groupID string) ([]Role, error) {
+	groupRoles, err := permissions.Store.GetGroupRoles([]string{groupID})
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get group roles")
+	}
+
+	var roleIDs []string
+	for _, gr := range groupRoles {
+		roleIDs = append(roleIDs, gr.RoleID)
+	}
+	roles, err := permissions.Store.GetRoles(roleIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get group roles")
+	}
+
+	return roles, nil
+}
+
+// groupGraph loads the group parent links reachable from groupIDs, one
+// store call per level of nesting
+func (permissions *Permissionist) groupGraph(groupIDs []string) (parentGraph, error) {
+	return loadParentGraph(groupIDs, func(ids []string) (parentGraph, error) {
+		links, err := permissions.Store.GetGroupParents(ids)
+		if err != nil {
+			return nil, err
+		}
+		parents := parentGraph{}
+		for _, link := range links {
+			parents[link.GroupID] = append(parents[link.GroupID], link.ParentID)
+		}
+		return parents, nil
+	})
+}
+
+// groupChildGraph loads the links to the groups nested in groupIDs,
+// directly or not, mapping each group to the groups nested directly in it
+func (permissions *Permissionist) groupChildGraph(groupIDs []string) (parentGraph, error) {
+	return loadParentGraph(groupIDs, func(ids []string) (parentGraph, error) {
+		links, err := permissions.Store.GetGroupChildren(ids)
+		if err != nil {
+			return nil, err
+		}
+		children := parentGraph{}
+		for _, link := range links {
+			children[link.ParentID] = append(children[link.ParentID], link.GroupID)
+		}
+		return children, nil
+	})
+}
+
+// groupRoles resolves, for each of entityIDs, the ids of the roles it
+// holds through the groups it belongs to, directly or through nesting
+func (permissions *Permissionist) groupRoles(entityIDs []string) (map[string][]string, error) {
+	memberships, err := permissions.Store.GetEntityGroups(entityIDs)
+	if err != nil {
+		return nil, err
+	}
+	var groupIDs []string
+	for _, member := range memberships {
+		groupIDs = append(groupIDs, member.GroupID)
+	}
+	graph, err := permissions.groupGraph(groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	var allGroupIDs []string
+	for groupID := range graph {
+		allGroupIDs = append(allGroupIDs, groupID)
+	}
+	groupRoles, err := permissions.Store.GetGroupRoles(allGroupIDs)
+	if err != nil {
+		return nil, err
+	}
+	rolesOf := map[string][]string{}
+	for _, gr := range groupRoles {
+		rolesOf[gr.GroupID] = append(rolesOf[gr.GroupID], gr.RoleID)
+	}
+
+	roles := map[string][]string{}
+	for _, member := range memberships {
+		for _, groupID := range graph.ancestors(member.GroupID) {
+			roles[member.EntityID] = append(roles[member.EntityID], rolesOf[groupID]...)
+		}
+	}
+	return roles, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// Errors returned when changing role inheritance
+var (
+	ErrRoleCycle   = errors.New("Role inheritance would create a cycle")
+	ErrAppMismatch = errors.New("Roles belong to different apps")
+)
+
+// AssignParentToRole makes role roleID inherit every permission of role
+// parentID. Both roles must belong to the same app, and the link is refused
+// if parentID already inherits from roleID.
+func (permissions *Permissionist) AssignParentToRole(roleID string, parentID string) error {
+	role, err := permissions.Store.GetRole(roleID)
+	if err != nil {
+		return errors.Wrap(err, "Could not get role")
+	}
+	parent, err := permissions.Store.GetRole(parentID)
+	if err != nil {
+		return errors.Wrap(err, "Could not get parent role")
+	}
+	if role.AppID != parent.AppID {
+		return ErrAppMismatch
+	}
+
+	graph, err := permissions.roleGraph([]string{parentID})
+	if err != nil {
+		return errors.Wrap(err, "Could not get role parents")
+	}
+	for _, ancestorID := range graph.ancestors(parentID) {
+		if ancestorID == roleID {
+			return ErrRoleCycle
+		}
+	}
+
+	err = permissions.Store.InsertRoleParent(RoleParent{
+		ID:       uuid.NewV4().String(),
+		RoleID:   roleID,
+		ParentID: parentID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not assign parent to role")
+	}
+
+	return nil
+}
+
+// UnassignParentFromRole stops role roleID inheriting from role parentID
+func (permissions *Permissionist) UnassignParentFromRole(roleID string, parentID string) error {
+	err := permissions.Store.DeleteRoleParent(roleID, parentID)
+	if err != nil {
+		return errors.Wrap(err, "Could not unassign parent from role")
+	}
+
+	return nil
+}
+
+// GetParentRoles returns the roles roleID directly inherits from
+func (permissions *Permissionist) GetParentRoles(roleID string) ([]Role, error) {
+	links, err := permissions.Store.GetRoleParents([]string{roleID})
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get role parents")
+	}
+
+	parents := []Role{}
+	for _, link := range links {
+		parent, err := permissions.Store.GetRole(link.ParentID)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not get role parents")
+		}
+		parents = append(parents, parent)
+	}
+
+	return parents, nil
+}
+
+// parentGraph maps a role or group id to the ids of its direct parents
+type parentGraph map[string][]string
+
+// loadParentGraph loads the parent links reachable from ids, calling
+// parentsOf, which maps ids to their direct parents, once per level
+func loadParentGraph(ids []string, parentsOf func(ids []string) (parentGraph, error)) (parentGraph, error) {
+	graph := parentGraph{}
+	frontier := ids
+	for len(frontier) > 0 {
+		for _, id := range frontier {
+			graph[id] = nil
+		}
+		parents, err := parentsOf(frontier)
+		if err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for id, parentIDs := range parents {
+			for _, parentID := range parentIDs {
+				graph[id] = append(graph[id], parentID)
+				if _, ok := graph[parentID]; !ok {
+					graph[parentID] = nil
+					frontier = append(frontier, parentID)
+				}
+			}
+		}
+	}
+	return graph, nil
+}
+
+// roleGraph loads the role parent links reachable from roleIDs, one store
+// call per level of inheritance
+func (permissions *Permissionist) roleGraph(roleIDs []string) (parentGraph, error) {
+	return loadParentGraph(roleIDs, func(ids []string) (parentGraph, error) {
+		links, err := permissions.Store.GetRoleParents(ids)
+		if err != nil {
+			return nil, err
+		}
+		parents := parentGraph{}
+		for _, link := range links {
+			parents[link.RoleID] = append(parents[link.RoleID], link.ParentID)
+		}
+		return parents, nil
+	})
+}
+
+// roleChildGraph loads the links to the roles inheriting from roleIDs,
+// directly or not, mapping each role to the roles inheriting directly from
+// it. The ancestors of a role in it are the role and its descendants.
+func (permissions *Permissionist) roleChildGraph(roleIDs []string) (parentGraph, error) {
+	return loadParentGraph(roleIDs, func(ids []string) (parentGraph, error) {
+		links, err := permissions.Store.GetRoleChildren(ids)
+		if err != nil {
+			return nil, err
+		}
+		children := parentGraph{}
+		for _, link := range links {
+			children[link.ParentID] = append(children[link.ParentID], link.RoleID)
+		}
+		return children, nil
+	})
+}
+
+// ancestors returns id followed by every id it inherits from
+func (graph parentGraph) ancestors(id string) []string {
+	seen := map[string]bool{id: true}
+	ancestors := []string{id}
+	for i := 0; i < len(ancestors); i++ {
+		for _, parentID := range graph[ancestors[i]] {
+			if !seen[parentID] {
+				seen[parentID] = true
+				ancestors = append(ancestors, parentID)
+			}
+		}
+	}
+	return ancestors
+}
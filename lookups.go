@@ -0,0 +1,202 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EntityPage is one page of entity ids. Entity ids have no name, so
+// ListOptions sort and filter them by id whatever OrderBy says.
+type EntityPage struct {
+	EntityIDs     []string `json:"entity_ids"`
+	NextPageToken string   `json:"next_page_token,omitempty"`
+}
+
+// ListEntitiesByRoleID returns a page of the entities currently holding role
+// roleID, assigned on any resource or through a group they belong to,
+// directly or through nesting. It is the reverse of GetRolesByEntityID.
+func (permissions *Permissionist) ListEntitiesByRoleID(roleID string, opts ListOptions) (EntityPage, error) {
+	query, err := opts.query()
+	if err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+	if _, err := permissions.Store.GetRole(roleID); err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+	entityIDs, err := permissions.listRoleHolders([]string{roleID}, query)
+	if err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+
+	return entityPage(entityIDs, query), nil
+}
+
+// ListEntitiesByPermissionID returns a page of the entities that
+// EntityIsAllowed allows permission permissionID: those holding a role that
+// grants it, or a wildcard matching it, directly or through inheritance,
+// and not denied it. Roles held through groups count, while assignments
+// scoped to a resource and grants under a condition do not.
+func (permissions *Permissionist) ListEntitiesByPermissionID(permissionID string, opts ListOptions) (EntityPage, error) {
+	query, err := opts.query()
+	if err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+	perm, err := permissions.GetPermission(permissionID)
+	if err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+	entityIDs, err := permissions.listPermissionHolders(perm, query)
+	if err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+
+	return entityPage(entityIDs, query), nil
+}
+
+// ListEntitiesByPermissionName is ListEntitiesByPermissionID for the
+// permission named permissionName in app appRef, which is either an app id
+// or an app name
+func (permissions *Permissionist) ListEntitiesByPermissionName(appRef string, permissionName string, opts ListOptions) (EntityPage, error) {
+	app, err := permissions.resolveApp(appRef)
+	if err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+	perm, err := permissions.Store.GetPermissionByName(app.ID, permissionName)
+	if err != nil {
+		return EntityPage{}, errors.Wrap(err, "Could not list entities")
+	}
+
+	return permissions.ListEntitiesByPermissionID(perm.ID, opts)
+}
+
+// holderChunkSize bounds the role holders whose grants are checked at once,
+// keeping the IN lists of those checks under the databases' parameter
+// limits
+const holderChunkSize = 250
+
+// listPermissionHolders returns the ids of the entities allowed perm, in
+// the order of query and starting after its page token. Only the holders
+// of roles granting perm or a wildcard matching it are checked, a chunk at
+// a time, until more than a page is found or none are left.
+func (permissions *Permissionist) listPermissionHolders(perm Permission, query ListQuery) ([]string, error) {
+	appPermissions, err := permissions.Store.GetPermissionsByAppID(perm.AppID)
+	if err != nil {
+		return nil, err
+	}
+	var grantingIDs []string
+	for _, p := range appPermissions {
+		if p.ID == perm.ID || permissionNameMatches(p.Name, perm.Name) {
+			grantingIDs = append(grantingIDs, p.ID)
+		}
+	}
+	rolePermissions, err := permissions.Store.GetRolePermissionsByPermissionIDs(grantingIDs)
+	if err != nil {
+		return nil, err
+	}
+	var roleIDs []string
+	for _, rp := range rolePermissions {
+		if !rp.Deny {
+			roleIDs = append(roleIDs, rp.RoledID)
+		}
+	}
+	graph, err := permissions.roleChildGraph(roleIDs)
+	if err != nil {
+		return nil, err
+	}
+	var grantingRoleIDs []string
+	for roleID := range graph {
+		grantingRoleIDs = append(grantingRoleIDs, roleID)
+	}
+	groupIDs, err := permissions.holderGroups(grantingRoleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	chunk := query
+	chunk.PageSize = holderChunkSize
+	var entityIDs []string
+	for !query.more(len(entityIDs)) {
+		candidates, err := permissions.Store.ListEntityIDsByRoleIDs(grantingRoleIDs, groupIDs, now, chunk)
+		if err != nil {
+			return nil, err
+		}
+		more := chunk.more(len(candidates))
+		if more {
+			candidates = candidates[:holderChunkSize]
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		// the candidates' grants settle denials, validity and conditions
+		// the same way EntityIsAllowed does
+		grants, err := permissions.entityGrants(candidates, Resource{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		index, err := permissions.permissionIndex(grants, []string{perm.ID})
+		if err != nil {
+			return nil, err
+		}
+		for _, entityID := range candidates {
+			if grants[entityID].allows(perm, index) {
+				entityIDs = append(entityIDs, entityID)
+			}
+		}
+		if !more {
+			break
+		}
+		last := candidates[len(candidates)-1]
+		chunk.After, chunk.AfterKey, chunk.AfterID = true, last, last
+	}
+	return entityIDs, nil
+}
+
+// listRoleHolders returns a page of the ids of the entities currently
+// holding any of roleIDs, assigned on any resource or through a group they
+// belong to
+func (permissions *Permissionist) listRoleHolders(roleIDs []string, query ListQuery) ([]string, error) {
+	groupIDs, err := permissions.holderGroups(roleIDs)
+	if err != nil {
+		return nil, err
+	}
+	return permissions.Store.ListEntityIDsByRoleIDs(roleIDs, groupIDs, time.Now(), query)
+}
+
+// holderGroups returns the ids of the groups whose members hold any of
+// roleIDs: those assigned one and the groups nested in them
+func (permissions *Permissionist) holderGroups(roleIDs []string) ([]string, error) {
+	if len(roleIDs) == 0 {
+		return nil, nil
+	}
+	groupRoles, err := permissions.Store.GetGroupRolesByRoleIDs(roleIDs)
+	if err != nil {
+		return nil, err
+	}
+	var groupIDs []string
+	for _, gr := range groupRoles {
+		groupIDs = append(groupIDs, gr.GroupID)
+	}
+	graph, err := permissions.groupChildGraph(groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	var allGroupIDs []string
+	for groupID := range graph {
+		allGroupIDs = append(allGroupIDs, groupID)
+	}
+	return allGroupIDs, nil
+}
+
+// entityPage trims entityIDs, listed with query, to a page
+func entityPage(entityIDs []string, query ListQuery) EntityPage {
+	page := EntityPage{EntityIDs: []string{}}
+	page.EntityIDs = append(page.EntityIDs, entityIDs...)
+	if query.more(len(page.EntityIDs)) {
+		page.EntityIDs = page.EntityIDs[:query.PageSize]
+		last := page.EntityIDs[query.PageSize-1]
+		page.NextPageToken = query.nextPageToken(last, last)
+	}
+	return page
+}
@@ -2,31 +2,26 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
-	"io/ioutil"
 	"log"
 	"net/http"
 )
 
 func handleCreateApp(P *Permissionist) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body := NewBody(w, r)
-		app, err := P.CreateApp(body.GetField("name"))
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not create app"))
+		var req createAppRequest
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
 			return
 		}
-		bytes, err := json.Marshal(&app)
+		app, err := P.CreateApp(req.Name)
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not parse json"))
+			writeError(w, err, "Could not create app")
 			return
 		}
-		w.WriteHeader(200)
-		w.Write(bytes)
+		writeJSON(w, app)
 	})
 }
 
@@ -35,55 +30,102 @@ func handleGetApp(P *Permissionist) http.HandlerFunc {
 		app, err := P.GetApp(mux.Vars(r)["appID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not get roles"))
+			writeError(w, err, "Could not get app")
 			return
 		}
-		w.WriteHeader(200)
-		w.Write([]byte(app.ID))
+		writeJSON(w, app)
 	})
 }
 
-func handleGetRoles(P *Permissionist) http.HandlerFunc {
+func handleGetApps(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptionsFromQuery(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		page, err := P.ListApps(opts)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get apps")
+			return
+		}
+		writeJSON(w, page)
+	})
+}
+
+func handleRenameApp(P *Permissionist) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		permissionNames, err := P.GetRolesByAppID(mux.Vars(r)["appID"])
+		req := renameAppRequest{AppID: mux.Vars(r)["appID"]}
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		app, err := P.RenameApp(req.AppID, req.Name)
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not get roles"))
+			writeError(w, err, "Could not rename app")
 			return
 		}
-		bytes, err := json.Marshal(permissionNames)
+		writeJSON(w, app)
+	})
+}
+
+func handleRemoveApp(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.RemoveApp(mux.Vars(r)["appID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not get roles"))
+			writeError(w, err, "Could not remove app")
 			return
 		}
 		w.WriteHeader(200)
-		w.Write(bytes)
 	})
 }
 
-func handleCreateRole(P *Permissionist) http.HandlerFunc {
+func handleGetPermissionsByAppID(P *Permissionist) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body := NewBody(w, r)
-		role, err := P.CreateRole(body.GetField("role_name"), mux.Vars(r)["appID"])
+		perms, err := P.GetPermissionsByAppID(mux.Vars(r)["appID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not create role"))
+			writeError(w, err, "Could not get permissions")
+			return
+		}
+		writeJSON(w, perms)
+	})
+}
+
+func handleGetRoles(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptionsFromQuery(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
 			return
 		}
-		bytes, err := json.Marshal(&role)
+		page, err := P.ListRolesByAppID(mux.Vars(r)["appID"], opts)
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not parse json"))
+			writeError(w, err, "Could not get roles")
 			return
 		}
-		w.WriteHeader(200)
-		w.Write(bytes)
+		writeJSON(w, page)
+	})
+}
+
+func handleCreateRole(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := createRoleRequest{AppID: mux.Vars(r)["appID"]}
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		role, err := P.CreateRole(req.RoleName, req.AppID)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not create role")
+			return
+		}
+		writeJSON(w, role)
 	})
 }
 
@@ -92,19 +134,39 @@ func handleGetRole(P *Permissionist) http.HandlerFunc {
 		role, err := P.GetRoleByID(mux.Vars(r)["roleID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not get role"))
+			writeError(w, err, "Could not get role")
+			return
+		}
+		writeJSON(w, role)
+	})
+}
+
+func handleRenameRole(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := renameRoleRequest{RoleID: mux.Vars(r)["roleID"]}
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		role, err := P.RenameRole(req.RoleID, req.RoleName)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not rename role")
 			return
 		}
-		bytes, err := json.Marshal(&role)
+		writeJSON(w, role)
+	})
+}
+
+func handleRemoveRole(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.RemoveRole(mux.Vars(r)["roleID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not parse json"))
+			writeError(w, err, "Could not remove role")
 			return
 		}
 		w.WriteHeader(200)
-		w.Write(bytes)
 	})
 }
 
@@ -113,85 +175,852 @@ func handleGetPermissionsByRoleID(P *Permissionist) http.HandlerFunc {
 		roles, err := P.GetPermissionsByRoleID(mux.Vars(r)["roleID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not get permissions"))
+			writeError(w, err, "Could not get permissions")
+			return
+		}
+		writeJSON(w, roles)
+	})
+}
+
+func handleCreatePermission(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req createPermissionRequest
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		permission, err := P.CreatePermission(req.Name, req.AppID)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not create permission")
 			return
 		}
-		bytes, err := json.Marshal(&roles)
+		writeJSON(w, permission)
+	})
+}
+
+func handleGetPermission(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permission, err := P.GetPermission(mux.Vars(r)["permissionID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not get permissions"))
+			writeError(w, err, "Could not get permission")
 			return
 		}
-		w.WriteHeader(200)
-		w.Write(bytes)
+		writeJSON(w, permission)
 	})
 }
 
-func handleCreatePermission(P *Permissionist) http.HandlerFunc {
+func handleRenamePermission(P *Permissionist) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body := NewBody(w, r)
-		permission, err := P.CreatePermission(body.GetField("name"), mux.Vars(r)["appID"])
+		req := renamePermissionRequest{PermissionID: mux.Vars(r)["permissionID"]}
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		permission, err := P.RenamePermission(req.PermissionID, req.Name)
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not create permission"))
+			writeError(w, err, "Could not rename permission")
 			return
 		}
-		bytes, err := json.Marshal(&permission)
+		writeJSON(w, permission)
+	})
+}
+
+func handleRemovePermission(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.RemovePermission(mux.Vars(r)["permissionID"])
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not create permission"))
+			writeError(w, err, "Could not remove permission")
 			return
 		}
 		w.WriteHeader(200)
-		w.Write(bytes)
 	})
 }
 
 func handleAssignPermissionToRole(P *Permissionist) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := P.AssignPermissionToRole(mux.Vars(r)["roleID"], mux.Vars(r)["permissionID"])
+		validity, err := validityFromQuery(r)
+		if err == nil {
+			err = P.grantPermissionToRole(mux.Vars(r)["roleID"], mux.Vars(r)["permissionID"], r.URL.Query().Get("condition"), validity)
+		}
 		if err != nil {
 			log.Println(err)
-			w.WriteHeader(500)
-			w.Write([]byte("Could not grant permission"))
+			writeError(w, err, "Could not grant permission")
 			return
 		}
 		w.WriteHeader(200)
 	})
 }
 
-func main() {
+func handleUnassignPermissionFromRole(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.UnassignPermissionFromRole(mux.Vars(r)["roleID"], mux.Vars(r)["permissionID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not unassign permission")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
 
-	config := InitConfig()
-	db := InitDb(config.GetString("database"))
+func handleGetParentRoles(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roles, err := P.GetParentRoles(mux.Vars(r)["roleID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get parent roles")
+			return
+		}
+		writeJSON(w, roles)
+	})
+}
 
-	schemas, err := ioutil.ReadFile("schemas.sql")
-	if err != nil {
-		log.Fatal(err)
-	}
+func handleAssignParentToRole(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.AssignParentToRole(mux.Vars(r)["roleID"], mux.Vars(r)["parentID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not assign parent")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
 
-	// Create schema
-	_, err = db.Exec(string(schemas))
-	if err != nil {
-		log.Fatal(err)
-	}
+func handleUnassignParentFromRole(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.UnassignParentFromRole(mux.Vars(r)["roleID"], mux.Vars(r)["parentID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not unassign parent")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
 
-	P := Permissionist{
-		DB: db,
-	}
+func handleGetRolesByEntityID(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptionsFromQuery(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		page, err := P.ListRolesByEntityID(entity.EntityID, opts)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get roles")
+			return
+		}
+		writeJSON(w, page)
+	})
+}
+
+func handleAssignRoleToEntity(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		validity, err := validityFromQuery(r)
+		if err == nil {
+			err = P.AssignTemporaryRoleToEntity(entity.EntityID, mux.Vars(r)["roleID"], entity.Resource, validity)
+		}
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not assign role")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleUnassignRoleFromEntity(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		err = P.UnassignRoleFromEntity(entity.EntityID, mux.Vars(r)["roleID"], entity.Resource)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not unassign role")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleGetDeniedPermissionsByRoleID(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perms, err := P.GetDeniedPermissionsByRoleID(mux.Vars(r)["roleID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get denied permissions")
+			return
+		}
+		if perms == nil {
+			perms = []Permission{}
+		}
+		writeJSON(w, perms)
+	})
+}
+
+func handleDenyPermissionToRole(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.DenyPermissionToRole(mux.Vars(r)["roleID"], mux.Vars(r)["permissionID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not deny permission")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleRemoveRoleDenial(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.RemoveRoleDenial(mux.Vars(r)["roleID"], mux.Vars(r)["permissionID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not remove denial")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleDenyPermissionToEntity(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		err = P.DenyPermissionToEntity(entity.EntityID, mux.Vars(r)["permissionID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not deny permission")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleRemoveEntityDenial(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		err = P.RemoveEntityDenial(entity.EntityID, mux.Vars(r)["permissionID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not remove denial")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleCreateGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := createGroupRequest{AppID: mux.Vars(r)["appID"]}
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		group, err := P.CreateGroup(req.GroupName, req.AppID)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not create group")
+			return
+		}
+		writeJSON(w, group)
+	})
+}
+
+func handleGetGroups(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		groups, err := P.GetGroupsByAppID(mux.Vars(r)["appID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get groups")
+			return
+		}
+		writeJSON(w, groups)
+	})
+}
+
+func handleGetGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group, err := P.GetGroup(mux.Vars(r)["groupID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get group")
+			return
+		}
+		writeJSON(w, group)
+	})
+}
+
+func handleRemoveGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.RemoveGroup(mux.Vars(r)["groupID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not remove group")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleGetGroupMembers(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entityIDs, err := P.GetGroupMembers(mux.Vars(r)["groupID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get group members")
+			return
+		}
+		writeJSON(w, entityIDs)
+	})
+}
+
+func handleAddEntityToGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		err = P.AddEntityToGroup(mux.Vars(r)["groupID"], entity.EntityID)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not add entity to group")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleRemoveEntityFromGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		err = P.RemoveEntityFromGroup(mux.Vars(r)["groupID"], entity.EntityID)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not remove entity from group")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleNestGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.NestGroup(mux.Vars(r)["groupID"], mux.Vars(r)["parentID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not nest group")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleUnnestGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.UnnestGroup(mux.Vars(r)["groupID"], mux.Vars(r)["parentID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not unnest group")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleGetRolesByGroupID(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roles, err := P.GetRolesByGroupID(mux.Vars(r)["groupID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get roles")
+			return
+		}
+		writeJSON(w, roles)
+	})
+}
+
+func handleAssignRoleToGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.AssignRoleToGroup(mux.Vars(r)["groupID"], mux.Vars(r)["roleID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not assign role")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleUnassignRoleFromGroup(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := P.UnassignRoleFromGroup(mux.Vars(r)["groupID"], mux.Vars(r)["roleID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not unassign role")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+// writeJSON responds 200 with value encoded as JSON
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		log.Println(err)
+		writeError(w, err, "Could not encode response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(bytes)
+}
+
+func handleEntityIsAllowed(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		check := Check{
+			EntityID:     entity.EntityID,
+			PermissionID: mux.Vars(r)["permissionID"],
+		}
+		allowed, err := P.EntityIsAllowedOn(check.EntityID, check.PermissionID, entity.Resource)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not check permission")
+			return
+		}
+		check.Allowed = allowed
+		writeJSON(w, check)
+	})
+}
+
+func handleEntityIsAllowedInContext(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := contextCheckRequest{PermissionID: mux.Vars(r)["permissionID"]}
+		req.EntityID, req.Resource = mux.Vars(r)["entityID"], resourceFromQuery(r)
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		check := Check{
+			EntityID:     req.EntityID,
+			PermissionID: req.PermissionID,
+		}
+		allowed, err := P.EntityIsAllowedInContext(check.EntityID, check.PermissionID, req.Resource, req.Context)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not check permission")
+			return
+		}
+		check.Allowed = allowed
+		writeJSON(w, check)
+	})
+}
+
+func handleListEntitiesByRoleID(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptionsFromQuery(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		page, err := P.ListEntitiesByRoleID(mux.Vars(r)["roleID"], opts)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not list entities")
+			return
+		}
+		writeJSON(w, page)
+	})
+}
+
+func handleListEntitiesByPermissionID(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptionsFromQuery(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		page, err := P.ListEntitiesByPermissionID(mux.Vars(r)["permissionID"], opts)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not list entities")
+			return
+		}
+		writeJSON(w, page)
+	})
+}
+
+func handleListEntitiesByPermissionName(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptionsFromQuery(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		page, err := P.ListEntitiesByPermissionName(mux.Vars(r)["app"], mux.Vars(r)["permissionName"], opts)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not list entities")
+			return
+		}
+		writeJSON(w, page)
+	})
+}
+
+func handleEntityIsAllowedByName(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity, err := entityFromRequest(r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		vars := mux.Vars(r)
+		check, err := P.EntityIsAllowedByName(entity.EntityID, vars["app"], vars["permissionName"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not check permission")
+			return
+		}
+		writeJSON(w, check)
+	})
+}
+
+func handleRoleIsAllowedByName(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		check, err := P.RoleIsAllowedByName(mux.Vars(r)["roleID"], mux.Vars(r)["permissionName"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not check permission")
+			return
+		}
+		writeJSON(w, check)
+	})
+}
+
+func handleEntitiesAreAllowed(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req checksRequest
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		results, err := P.EntitiesAreAllowed(req.Checks)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not check permissions")
+			return
+		}
+		writeJSON(w, results)
+	})
+}
+
+func handlePutNamespace(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := putNamespaceRequest{Name: mux.Vars(r)["namespace"]}
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		if err := P.PutNamespace(Namespace{Name: req.Name, Relations: req.Relations}); err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not put namespace")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleGetNamespace(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, err := P.GetNamespace(mux.Vars(r)["namespace"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not get namespace")
+			return
+		}
+		writeJSON(w, namespace)
+	})
+}
+
+func handleWriteRelationTuple(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req relationTupleRequest
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		if err := P.WriteRelationTuple(req.RelationTuple); err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not write relation tuple")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleDeleteRelationTuple(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req relationTupleRequest
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		if err := P.DeleteRelationTuple(req.RelationTuple); err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not delete relation tuple")
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleCheckRelation(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		allowed, err := P.CheckRelation(vars["namespace"], vars["objectID"], vars["relation"], vars["subjectID"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not check relation")
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"namespace":  vars["namespace"],
+			"object_id":  vars["objectID"],
+			"relation":   vars["relation"],
+			"subject_id": vars["subjectID"],
+			"allowed":    allowed,
+		})
+	})
+}
+
+func handleExpandRelation(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		tree, err := P.ExpandRelation(vars["namespace"], vars["objectID"], vars["relation"])
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not expand relation")
+			return
+		}
+		writeJSON(w, tree)
+	})
+}
+
+func handleExportApp(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		export, err := P.ExportApp(mux.Vars(r)["appID"], r.URL.Query().Get("entity_roles") == "true")
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not export app")
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		document, err := MarshalExport(export, format)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not encode export")
+			return
+		}
+		if format == "yaml" {
+			w.Header().Set("Content-Type", "application/x-yaml")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(200)
+		w.Write(document)
+	})
+}
+
+func handleImportApp(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		document, err := readRequest(w, r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		export, err := UnmarshalExport(document)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not parse export")
+			return
+		}
+		app, err := P.ImportApp(export)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not import app")
+			return
+		}
+		writeJSON(w, app)
+	})
+}
+
+func handleApplyBatch(P *Permissionist) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req batchRequest
+		if err := decodeRequest(w, r, &req); err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		results, err := P.ApplyBatch(req.Operations)
+		if err != nil {
+			log.Println(err)
+			if batchErr, ok := err.(*BatchError); ok {
+				writeError(w, err, fmt.Sprintf("Could not apply batch: operation %d (%s) failed", batchErr.Index, batchErr.Op))
+				return
+			}
+			writeError(w, err, "Could not apply batch")
+			return
+		}
+		writeJSON(w, results)
+	})
+}
+
+// handlePolicy reads a policy document from the request body and responds
+// with the plan that run, PlanPolicy or ApplyPolicy, returns for it
+func handlePolicy(run func(Policy) (PolicyPlan, error)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		document, err := readRequest(w, r)
+		if err != nil {
+			writeError(w, err, "Could not process request")
+			return
+		}
+		policy, err := ParsePolicy(document)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not parse policy")
+			return
+		}
+		plan, err := run(policy)
+		if err != nil {
+			log.Println(err)
+			writeError(w, err, "Could not process policy")
+			return
+		}
+		writeJSON(w, plan)
+	})
+}
+
+func main() {
+
+	config := InitConfig()
+
+	P := Permissionist{
+		Store: InitStore(config),
+	}
+
+	P.StartSweeper(config.GetDuration("sweep_interval"), func(report SweepReport, err error) {
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for _, er := range report.EntityRoles {
+			log.Printf("Removed expired role %s from entity %s", er.RoleID, er.EntityID)
+		}
+		for _, rp := range report.RolePermissions {
+			log.Printf("Removed expired permission %s from role %s", rp.PermissionID, rp.RoledID)
+		}
+	})
 
 	router := mux.NewRouter()
 
+	router.HandleFunc("/apps", handleGetApps(&P)).Methods("GET")
 	router.HandleFunc("/apps", handleCreateApp(&P)).Methods("POST")
 	router.HandleFunc("/apps/{appID}", handleGetApp(&P)).Methods("GET")
+	router.HandleFunc("/apps/{appID}", handleRenameApp(&P)).Methods("PATCH")
+	router.HandleFunc("/apps/{appID}", handleRemoveApp(&P)).Methods("DELETE")
+	router.HandleFunc("/apps/{appID}/permissions", handleGetPermissionsByAppID(&P)).Methods("GET")
 	router.HandleFunc("/apps/{appID}/roles", handleGetRoles(&P)).Methods("GET")
 	router.HandleFunc("/apps/{appID}/roles", handleCreateRole(&P)).Methods("POST")
+	router.HandleFunc("/roles/{roleID}", handleGetRole(&P)).Methods("GET")
+	router.HandleFunc("/roles/{roleID}", handleRenameRole(&P)).Methods("PATCH")
+	router.HandleFunc("/roles/{roleID}", handleRemoveRole(&P)).Methods("DELETE")
 	router.HandleFunc("/roles/{roleID}/permissions", handleGetPermissionsByRoleID(&P)).Methods("GET")
 	router.HandleFunc("/roles/{roleID}/permissions/{permissionID}", handleAssignPermissionToRole(&P)).Methods("POST")
+	router.HandleFunc("/roles/{roleID}/permissions/{permissionID}", handleUnassignPermissionFromRole(&P)).Methods("DELETE")
+	router.HandleFunc("/roles/{roleID}/entities", handleListEntitiesByRoleID(&P)).Methods("GET")
+	router.HandleFunc("/roles/{roleID}/parents", handleGetParentRoles(&P)).Methods("GET")
+	router.HandleFunc("/roles/{roleID}/parents/{parentID}", handleAssignParentToRole(&P)).Methods("PUT")
+	router.HandleFunc("/roles/{roleID}/parents/{parentID}", handleUnassignParentFromRole(&P)).Methods("DELETE")
+	router.HandleFunc("/roles/{roleID}/denials", handleGetDeniedPermissionsByRoleID(&P)).Methods("GET")
+	router.HandleFunc("/roles/{roleID}/denials/{permissionID}", handleDenyPermissionToRole(&P)).Methods("PUT")
+	router.HandleFunc("/roles/{roleID}/denials/{permissionID}", handleRemoveRoleDenial(&P)).Methods("DELETE")
 	router.HandleFunc("/permissions", handleCreatePermission(&P)).Methods("POST")
+	router.HandleFunc("/permissions/{permissionID}", handleGetPermission(&P)).Methods("GET")
+	router.HandleFunc("/permissions/{permissionID}", handleRenamePermission(&P)).Methods("PATCH")
+	router.HandleFunc("/permissions/{permissionID}", handleRemovePermission(&P)).Methods("DELETE")
+	router.HandleFunc("/permissions/{permissionID}/entities", handleListEntitiesByPermissionID(&P)).Methods("GET")
+	router.HandleFunc("/apps/{appID}/groups", handleGetGroups(&P)).Methods("GET")
+	router.HandleFunc("/apps/{appID}/groups", handleCreateGroup(&P)).Methods("POST")
+	router.HandleFunc("/groups/{groupID}", handleGetGroup(&P)).Methods("GET")
+	router.HandleFunc("/groups/{groupID}", handleRemoveGroup(&P)).Methods("DELETE")
+	router.HandleFunc("/groups/{groupID}/members", handleGetGroupMembers(&P)).Methods("GET")
+	router.HandleFunc("/groups/{groupID}/members/{entityID}", handleAddEntityToGroup(&P)).Methods("PUT")
+	router.HandleFunc("/groups/{groupID}/members/{entityID}", handleRemoveEntityFromGroup(&P)).Methods("DELETE")
+	router.HandleFunc("/groups/{groupID}/parents/{parentID}", handleNestGroup(&P)).Methods("PUT")
+	router.HandleFunc("/groups/{groupID}/parents/{parentID}", handleUnnestGroup(&P)).Methods("DELETE")
+	router.HandleFunc("/groups/{groupID}/roles", handleGetRolesByGroupID(&P)).Methods("GET")
+	router.HandleFunc("/groups/{groupID}/roles/{roleID}", handleAssignRoleToGroup(&P)).Methods("PUT")
+	router.HandleFunc("/groups/{groupID}/roles/{roleID}", handleUnassignRoleFromGroup(&P)).Methods("DELETE")
+	router.HandleFunc("/entities/{entityID}/roles", handleGetRolesByEntityID(&P)).Methods("GET")
+	router.HandleFunc("/entities/{entityID}/roles/{roleID}", handleAssignRoleToEntity(&P)).Methods("PUT")
+	router.HandleFunc("/entities/{entityID}/roles/{roleID}", handleUnassignRoleFromEntity(&P)).Methods("DELETE")
+	router.HandleFunc("/entities/{entityID}/permissions/{permissionID}", handleEntityIsAllowed(&P)).Methods("GET")
+	router.HandleFunc("/entities/{entityID}/permissions/{permissionID}", handleEntityIsAllowedInContext(&P)).Methods("POST")
+	router.HandleFunc("/entities/{entityID}/denials/{permissionID}", handleDenyPermissionToEntity(&P)).Methods("PUT")
+	router.HandleFunc("/entities/{entityID}/denials/{permissionID}", handleRemoveEntityDenial(&P)).Methods("DELETE")
+	router.HandleFunc("/checks", handleEntitiesAreAllowed(&P)).Methods("POST")
+	router.HandleFunc("/apps/{app}/entities/{entityID}/permissions/{permissionName}", handleEntityIsAllowedByName(&P)).Methods("GET")
+	router.HandleFunc("/apps/{app}/permissions/{permissionName}/entities", handleListEntitiesByPermissionName(&P)).Methods("GET")
+	router.HandleFunc("/roles/{roleID}/permissions/{permissionName}", handleRoleIsAllowedByName(&P)).Methods("GET")
+	router.HandleFunc("/namespaces/{namespace}", handleGetNamespace(&P)).Methods("GET")
+	router.HandleFunc("/namespaces/{namespace}", handlePutNamespace(&P)).Methods("PUT")
+	router.HandleFunc("/namespaces/{namespace}/objects/{objectID}/relations/{relation}", handleExpandRelation(&P)).Methods("GET")
+	router.HandleFunc("/namespaces/{namespace}/objects/{objectID}/relations/{relation}/subjects/{subjectID}", handleCheckRelation(&P)).Methods("GET")
+	router.HandleFunc("/relation-tuples", handleWriteRelationTuple(&P)).Methods("POST")
+	router.HandleFunc("/relation-tuples", handleDeleteRelationTuple(&P)).Methods("DELETE")
+	router.HandleFunc("/policies/plan", handlePolicy(P.PlanPolicy)).Methods("POST")
+	router.HandleFunc("/policies/apply", handlePolicy(P.ApplyPolicy)).Methods("POST")
+	router.HandleFunc("/apps/{appID}/export", handleExportApp(&P)).Methods("GET")
+	router.HandleFunc("/apps/import", handleImportApp(&P)).Methods("POST")
+	router.HandleFunc("/batch", handleApplyBatch(&P)).Methods("POST")
 	http.ListenAndServe(":8000", router)
 }
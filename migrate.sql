@@ -17,15 +17,94 @@ CREATE TABLE IF NOT EXISTS permissions (
 	UNIQUE (app_id, name)
 );
 
+CREATE TABLE IF NOT EXISTS role_parents (
+	id UUID PRIMARY KEY,
+	role_id UUID NOT NULL REFERENCES roles ON DELETE CASCADE,
+	parent_id UUID NOT NULL REFERENCES roles ON DELETE CASCADE,
+	UNIQUE (role_id, parent_id)
+);
+
 CREATE TABLE IF NOT EXISTS role_permissions (
 	id UUID PRIMARY KEY,
 	permission_id UUID NOT NULL REFERENCES permissions ON DELETE CASCADE,
-	role_id UUID NOT NULL REFERENCES roles ON DELETE CASCADE
+	role_id UUID NOT NULL REFERENCES roles ON DELETE CASCADE,
+	deny BOOLEAN NOT NULL DEFAULT FALSE,
+	condition TEXT NOT NULL DEFAULT '',
+	not_before TIMESTAMPTZ,
+	expires_at TIMESTAMPTZ
 );
 
 CREATE TABLE IF NOT EXISTS entity_roles (
 	id UUID PRIMARY KEY,
 	role_id UUID NOT NULL REFERENCES roles ON DELETE CASCADE,
 	entity_id VARCHAR(60) NOT NULL,
-	UNIQUE (entity_id, role_id)
-);
\ No newline at end of file
+	resource_type VARCHAR(60) NOT NULL DEFAULT '',
+	resource_id VARCHAR(60) NOT NULL DEFAULT '',
+	not_before TIMESTAMPTZ,
+	expires_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS entity_denials (
+	id UUID PRIMARY KEY,
+	permission_id UUID NOT NULL REFERENCES permissions ON DELETE CASCADE,
+	entity_id VARCHAR(60) NOT NULL,
+	UNIQUE (entity_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS entity_groups (
+	id UUID PRIMARY KEY,
+	app_id UUID NOT NULL REFERENCES apps ON DELETE CASCADE,
+	name VARCHAR(60) NOT NULL,
+	UNIQUE (app_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	id UUID PRIMARY KEY,
+	group_id UUID NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	entity_id VARCHAR(60) NOT NULL,
+	UNIQUE (group_id, entity_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_parents (
+	id UUID PRIMARY KEY,
+	group_id UUID NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	parent_id UUID NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	UNIQUE (group_id, parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_roles (
+	id UUID PRIMARY KEY,
+	group_id UUID NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	role_id UUID NOT NULL REFERENCES roles ON DELETE CASCADE,
+	UNIQUE (group_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS relation_namespaces (
+	name VARCHAR(60) PRIMARY KEY,
+	config TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS relation_tuples (
+	namespace VARCHAR(60) NOT NULL REFERENCES relation_namespaces ON DELETE CASCADE,
+	object_id VARCHAR(60) NOT NULL,
+	relation VARCHAR(60) NOT NULL,
+	subject_id VARCHAR(60) NOT NULL DEFAULT '',
+	subject_namespace VARCHAR(60) NOT NULL DEFAULT '',
+	subject_object_id VARCHAR(60) NOT NULL DEFAULT '',
+	subject_relation VARCHAR(60) NOT NULL DEFAULT '',
+	PRIMARY KEY (namespace, object_id, relation, subject_id, subject_namespace, subject_object_id, subject_relation)
+);
+
+-- Columns added after a table was first created
+ALTER TABLE role_permissions ADD COLUMN IF NOT EXISTS deny BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE entity_roles ADD COLUMN IF NOT EXISTS resource_type VARCHAR(60) NOT NULL DEFAULT '';
+ALTER TABLE entity_roles ADD COLUMN IF NOT EXISTS resource_id VARCHAR(60) NOT NULL DEFAULT '';
+
+-- entity_roles used to be unique on (entity_id, role_id) alone
+ALTER TABLE entity_roles DROP CONSTRAINT IF EXISTS entity_roles_entity_id_role_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS entity_roles_assignment_key ON entity_roles (entity_id, role_id, resource_type, resource_id);
+ALTER TABLE role_permissions ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ;
+ALTER TABLE role_permissions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE entity_roles ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ;
+ALTER TABLE entity_roles ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE role_permissions ADD COLUMN IF NOT EXISTS condition TEXT NOT NULL DEFAULT '';
\ No newline at end of file
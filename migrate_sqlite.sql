@@ -0,0 +1,97 @@
+CREATE TABLE IF NOT EXISTS apps (
+	id TEXT PRIMARY KEY,
+	name VARCHAR(60) UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+	id TEXT PRIMARY KEY,
+	app_id TEXT NOT NULL REFERENCES apps ON DELETE CASCADE,
+	name VARCHAR(60) NOT NULL,
+	UNIQUE (app_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	id TEXT PRIMARY KEY,
+	app_id TEXT NOT NULL REFERENCES apps ON DELETE CASCADE,
+	name VARCHAR(60) NOT NULL,
+	UNIQUE (app_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS role_parents (
+	id TEXT PRIMARY KEY,
+	role_id TEXT NOT NULL REFERENCES roles ON DELETE CASCADE,
+	parent_id TEXT NOT NULL REFERENCES roles ON DELETE CASCADE,
+	UNIQUE (role_id, parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	id TEXT PRIMARY KEY,
+	permission_id TEXT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+	role_id TEXT NOT NULL REFERENCES roles ON DELETE CASCADE,
+	deny BOOLEAN NOT NULL DEFAULT FALSE,
+	condition TEXT NOT NULL DEFAULT '',
+	not_before DATETIME,
+	expires_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS entity_roles (
+	id TEXT PRIMARY KEY,
+	role_id TEXT NOT NULL REFERENCES roles ON DELETE CASCADE,
+	entity_id VARCHAR(60) NOT NULL,
+	resource_type VARCHAR(60) NOT NULL DEFAULT '',
+	resource_id VARCHAR(60) NOT NULL DEFAULT '',
+	not_before DATETIME,
+	expires_at DATETIME,
+	UNIQUE (entity_id, role_id, resource_type, resource_id)
+);
+
+CREATE TABLE IF NOT EXISTS entity_denials (
+	id TEXT PRIMARY KEY,
+	permission_id TEXT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+	entity_id VARCHAR(60) NOT NULL,
+	UNIQUE (entity_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS entity_groups (
+	id TEXT PRIMARY KEY,
+	app_id TEXT NOT NULL REFERENCES apps ON DELETE CASCADE,
+	name VARCHAR(60) NOT NULL,
+	UNIQUE (app_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	id TEXT PRIMARY KEY,
+	group_id TEXT NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	entity_id VARCHAR(60) NOT NULL,
+	UNIQUE (group_id, entity_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_parents (
+	id TEXT PRIMARY KEY,
+	group_id TEXT NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	parent_id TEXT NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	UNIQUE (group_id, parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_roles (
+	id TEXT PRIMARY KEY,
+	group_id TEXT NOT NULL REFERENCES entity_groups ON DELETE CASCADE,
+	role_id TEXT NOT NULL REFERENCES roles ON DELETE CASCADE,
+	UNIQUE (group_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS relation_namespaces (
+	name VARCHAR(60) PRIMARY KEY,
+	config TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS relation_tuples (
+	namespace VARCHAR(60) NOT NULL REFERENCES relation_namespaces ON DELETE CASCADE,
+	object_id VARCHAR(60) NOT NULL,
+	relation VARCHAR(60) NOT NULL,
+	subject_id VARCHAR(60) NOT NULL DEFAULT '',
+	subject_namespace VARCHAR(60) NOT NULL DEFAULT '',
+	subject_object_id VARCHAR(60) NOT NULL DEFAULT '',
+	subject_relation VARCHAR(60) NOT NULL DEFAULT '',
+	PRIMARY KEY (namespace, object_id, relation, subject_id, subject_namespace, subject_object_id, subject_relation)
+);
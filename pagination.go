@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned for bad ListOptions
+var (
+	ErrInvalidPageToken = errors.New("Invalid page token")
+	ErrInvalidOrder     = errors.New("Invalid order")
+)
+
+// Page sizes used when ListOptions leaves PageSize out, and the most a
+// single page can hold
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// ListOptions pages, filters and sorts a list. Pass the NextPageToken of one
+// page as the PageToken of the next, keeping the other options the same.
+type ListOptions struct {
+	// PageSize is the most records to return, defaultPageSize if 0 and at
+	// most maxPageSize
+	PageSize int
+	// PageToken picks up where a previous page ended
+	PageToken string
+	// Prefix keeps only records whose name starts with it
+	Prefix string
+	// OrderBy is "name", the default, or "id", with a leading "-" to sort
+	// descending
+	OrderBy string
+}
+
+// ListQuery is ListOptions resolved for a Store. Stores return the records
+// whose name starts with Prefix, ordered by OrderBy and then id, descending
+// if Desc, coming after AfterKey and AfterID if After is set. They return up
+// to PageSize+1 records so that callers can tell if another page follows.
+type ListQuery struct {
+	Prefix   string
+	OrderBy  string
+	Desc     bool
+	After    bool
+	AfterKey string
+	AfterID  string
+	PageSize int
+}
+
+// AppPage is one page of apps
+type AppPage struct {
+	Apps          []App  `json:"apps"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// RolePage is one page of roles
+type RolePage struct {
+	Roles         []Role `json:"roles"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// pageToken is where a page ended, along with the options it was listed
+// with so that a token cannot be replayed against a different list
+type pageToken struct {
+	OrderBy string `json:"o"`
+	Prefix  string `json:"p"`
+	Key     string `json:"k"`
+	ID      string `json:"i"`
+}
+
+// query checks opts and resolves them into a ListQuery
+func (opts ListOptions) query() (ListQuery, error) {
+	query := ListQuery{Prefix: opts.Prefix, PageSize: opts.PageSize}
+	if query.PageSize <= 0 {
+		query.PageSize = defaultPageSize
+	}
+	if query.PageSize > maxPageSize {
+		query.PageSize = maxPageSize
+	}
+	orderBy := opts.OrderBy
+	if orderBy == "" {
+		orderBy = "name"
+	}
+	query.OrderBy = strings.TrimPrefix(orderBy, "-")
+	query.Desc = query.OrderBy != orderBy
+	if query.OrderBy != "name" && query.OrderBy != "id" {
+		return query, errors.Wrapf(ErrInvalidOrder, "Cannot order by %q", opts.OrderBy)
+	}
+	if opts.PageToken == "" {
+		return query, nil
+	}
+	var token pageToken
+	bytes, err := base64.RawURLEncoding.DecodeString(opts.PageToken)
+	if err == nil {
+		err = json.Unmarshal(bytes, &token)
+	}
+	if err != nil {
+		return query, ErrInvalidPageToken
+	}
+	if token.OrderBy != orderBy || token.Prefix != opts.Prefix {
+		return query, errors.Wrap(ErrInvalidPageToken, "Page token was made with other options")
+	}
+	query.After = true
+	query.AfterKey = token.Key
+	query.AfterID = token.ID
+	return query, nil
+}
+
+// more checks if a store returned more records than fit on the page
+func (query ListQuery) more(count int) bool {
+	return count > query.PageSize
+}
+
+// nextPageToken returns the token for the page after the record with name
+// and id
+func (query ListQuery) nextPageToken(name string, id string) string {
+	token := pageToken{OrderBy: query.OrderBy, Prefix: query.Prefix, Key: query.key(name, id), ID: id}
+	if query.Desc {
+		token.OrderBy = "-" + token.OrderBy
+	}
+	bytes, _ := json.Marshal(&token)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// key returns what a record with name and id is sorted by
+func (query ListQuery) key(name string, id string) string {
+	if query.OrderBy == "id" {
+		return id
+	}
+	return name
+}
+
+// before checks if the record with keyA and idA sorts before the one with
+// keyB and idB
+func (query ListQuery) before(keyA string, idA string, keyB string, idB string) bool {
+	if keyA == keyB {
+		keyA, keyB = idA, idB
+	}
+	if query.Desc {
+		return keyA > keyB
+	}
+	return keyA < keyB
+}
+
+// page applies query in memory to count records, given the name and id of
+// each, and returns the indexes of the records on the page in order
+func (query ListQuery) page(count int, name func(i int) string, id func(i int) string) []int {
+	var indexes []int
+	for i := 0; i < count; i++ {
+		if !strings.HasPrefix(name(i), query.Prefix) {
+			continue
+		}
+		if query.After && !query.before(query.AfterKey, query.AfterID, query.key(name(i), id(i)), id(i)) {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		i, j := indexes[a], indexes[b]
+		return query.before(query.key(name(i), id(i)), id(i), query.key(name(j), id(j)), id(j))
+	})
+	if len(indexes) > query.PageSize+1 {
+		indexes = indexes[:query.PageSize+1]
+	}
+	return indexes
+}
+
+// pageApps applies query to apps in memory
+func pageApps(apps []App, query ListQuery) []App {
+	paged := []App{}
+	for _, i := range query.page(len(apps), func(i int) string { return apps[i].Name }, func(i int) string { return apps[i].ID }) {
+		paged = append(paged, apps[i])
+	}
+	return paged
+}
+
+// pageRoles applies query to roles in memory
+func pageRoles(roles []Role, query ListQuery) []Role {
+	paged := []Role{}
+	for _, i := range query.page(len(roles), func(i int) string { return roles[i].Name }, func(i int) string { return roles[i].ID }) {
+		paged = append(paged, roles[i])
+	}
+	return paged
+}
+
+// pageEntityIDs applies query to entityIDs in memory, each id serving as
+// its own name
+func pageEntityIDs(entityIDs []string, query ListQuery) []string {
+	id := func(i int) string { return entityIDs[i] }
+	paged := []string{}
+	for _, i := range query.page(len(entityIDs), id, id) {
+		paged = append(paged, entityIDs[i])
+	}
+	return paged
+}
+
+// sql returns a WHERE clause joining conditions with the ones query adds,
+// followed by its ORDER BY and LIMIT, along with the arguments for both
+func (query ListQuery) sql(conditions []string, args []interface{}) (string, []interface{}) {
+	if query.Prefix != "" {
+		conditions = append(conditions, "substr(name, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(query.Prefix), query.Prefix)
+	}
+	// OrderBy is name or id, checked by ListOptions.query
+	key, op, direction := query.OrderBy, ">", "ASC"
+	if query.Desc {
+		op, direction = "<", "DESC"
+	}
+	if query.After {
+		conditions = append(conditions, "("+key+" "+op+" ? OR ("+key+" = ? AND id "+op+" ?))")
+		args = append(args, query.AfterKey, query.AfterKey, query.AfterID)
+	}
+	var clause string
+	if len(conditions) > 0 {
+		clause = "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	clause += "ORDER BY " + key + " " + direction + ", id " + direction + "\nLIMIT ?"
+	args = append(args, query.PageSize+1)
+	return clause, args
+}
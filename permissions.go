@@ -1,11 +1,8 @@
 package main
 
 import (
-	"fmt"
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
-	"strings"
 )
 
 // App app schema
@@ -14,11 +11,15 @@ type App struct {
 	Name string `json:"name" db:"name"`
 }
 
-// RolePermission role_permissions schema
+// RolePermission role_permissions schema. A deny row overrides any grant
+// of the same permission.
 type RolePermission struct {
 	ID           string `json:"id" db:"id"`
 	RoledID      string `json:"role_id" db:"role_id"`
 	PermissionID string `json:"permission_id" db:"permission_id"`
+	Deny         bool   `json:"deny" db:"deny"`
+	Condition    string `json:"condition,omitempty" db:"condition"`
+	Validity
 }
 
 // Permission permissions schema
@@ -35,64 +36,154 @@ type Role struct {
 	AppID string `json:"app_id" db:"app_id"`
 }
 
+// Check is the result of an authorization check for an entity or a role
+type Check struct {
+	EntityID     string `json:"entity_id,omitempty"`
+	RoleID       string `json:"role_id,omitempty"`
+	PermissionID string `json:"permission_id"`
+	Allowed      bool   `json:"allowed"`
+}
+
 // Permissionist owns permissions crud
 type Permissionist struct {
-	DB *sqlx.DB
+	Store Store
 }
 
 // EntityIsAllowed checks if entity entityID has permission permissionID
+// through any of its roles or the roles they inherit from. Only roles
+// assigned without a resource count; see EntityIsAllowedOn.
 func (permissions *Permissionist) EntityIsAllowed(entityID string, permissionID string) (bool, error) {
-	var perms []string
-	err := permissions.DB.Select(&perms, `
-	SELECT p.id
-	FROM permissions AS p
-	INNER JOIN entity_roles AS er
-		ON er.entity_id = $1
-			AND p.id = $2
-	INNER JOIN role_permissions AS rp
-		ON rp.permission_id = $2
-			AND rp.permission_id = p.id
-			AND rp.role_id = er.role_id;
-	`, entityID, permissionID)
+	return permissions.EntityIsAllowedOn(entityID, permissionID, Resource{})
+}
 
+// EntityIsAllowedOn checks if entity entityID has permission permissionID on
+// resource, through roles assigned on that resource or without one.
+// Conditional grants never apply; see EntityIsAllowedInContext.
+func (permissions *Permissionist) EntityIsAllowedOn(entityID string, permissionID string, resource Resource) (bool, error) {
+	return permissions.EntityIsAllowedInContext(entityID, permissionID, resource, nil)
+}
+
+// EntityIsAllowedInContext is EntityIsAllowedOn counting the conditional
+// grants whose condition holds in context
+func (permissions *Permissionist) EntityIsAllowedInContext(entityID string, permissionID string, resource Resource, context Context) (bool, error) {
+	if err := checkUUIDs(permissionID); err != nil {
+		return false, errors.Wrap(err, "Could not check permission")
+	}
+	if err := resource.check(); err != nil {
+		return false, errors.Wrap(err, "Could not check permission")
+	}
+	grants, err := permissions.entityGrants([]string{entityID}, resource, context)
+	if err != nil {
+		return false, errors.Wrap(err, "Could not check permission")
+	}
+	index, err := permissions.permissionIndex(grants, []string{permissionID})
 	if err != nil {
 		return false, errors.Wrap(err, "Could not check permission")
 	}
 
-	if len(perms) == 0 {
-		return false, nil
+	return grants[entityID].allows(index[permissionID], index), nil
+}
+
+// EntitiesAreAllowed checks many entity/permission pairs with a fixed number
+// of store calls, however many pairs there are. The result maps entity id to
+// permission id to allowed, with an entry for every requested pair.
+func (permissions *Permissionist) EntitiesAreAllowed(checks []Check) (map[string]map[string]bool, error) {
+	var entityIDs, permissionIDs []string
+	for _, check := range checks {
+		if err := checkUUIDs(check.PermissionID); err != nil {
+			return nil, errors.Wrap(err, "Could not check permissions")
+		}
+		entityIDs = append(entityIDs, check.EntityID)
+		permissionIDs = append(permissionIDs, check.PermissionID)
+	}
+	grants, err := permissions.entityGrants(entityIDs, Resource{}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not check permissions")
+	}
+	index, err := permissions.permissionIndex(grants, permissionIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not check permissions")
+	}
+
+	results := map[string]map[string]bool{}
+	for _, check := range checks {
+		if results[check.EntityID] == nil {
+			results[check.EntityID] = map[string]bool{}
+		}
+		results[check.EntityID][check.PermissionID] = grants[check.EntityID].allows(index[check.PermissionID], index)
 	}
 
-	return true, nil
+	return results, nil
 }
 
-// RoleIsAllowed checks if entity roleID has permission permissionID
-func (permissions *Permissionist) RoleIsAllowed(roleID string, permissionID string) (bool, error) {
-	var rolePermissionIDs []string
-	err := permissions.DB.Select(&rolePermissionIDs, `
-	SELECT rp.id
-	FROM permissions AS p
-	INNER JOIN role_permissions AS rp
-		ON p.id = $2
-			AND rp.permission_id = p.id
-			AND rp.role_id = $1;
-	`, roleID, permissionID)
+// EntityIsAllowedByName checks if entity entityID has the permission named
+// permissionName in app appRef, which is either an app id or an app name
+func (permissions *Permissionist) EntityIsAllowedByName(entityID string, appRef string, permissionName string) (Check, error) {
+	check := Check{EntityID: entityID}
+	app, err := permissions.resolveApp(appRef)
+	if err != nil {
+		return check, errors.Wrap(err, "Could not check permission")
+	}
+	perm, err := permissions.Store.GetPermissionByName(app.ID, permissionName)
+	if err != nil {
+		return check, errors.Wrap(err, "Could not check permission")
+	}
+	check.PermissionID = perm.ID
+	check.Allowed, err = permissions.EntityIsAllowed(entityID, perm.ID)
 
+	return check, err
+}
+
+// RoleIsAllowedByName checks if role roleID has the permission named
+// permissionName in the role's app
+func (permissions *Permissionist) RoleIsAllowedByName(roleID string, permissionName string) (Check, error) {
+	check := Check{RoleID: roleID}
+	role, err := permissions.Store.GetRole(roleID)
 	if err != nil {
-		return false, errors.Wrap(err, "Could not check permission")
+		return check, errors.Wrap(err, "Could not check permission")
+	}
+	perm, err := permissions.Store.GetPermissionByName(role.AppID, permissionName)
+	if err != nil {
+		return check, errors.Wrap(err, "Could not check permission")
+	}
+	check.PermissionID = perm.ID
+	check.Allowed, err = permissions.RoleIsAllowed(roleID, perm.ID)
+
+	return check, err
+}
+
+// resolveApp looks an app up by id, falling back to its name
+func (permissions *Permissionist) resolveApp(appRef string) (App, error) {
+	if _, err := uuid.FromString(appRef); err == nil {
+		app, err := permissions.Store.GetApp(appRef)
+		if errors.Cause(err) != ErrNotFound {
+			return app, err
+		}
 	}
+	return permissions.Store.GetAppByName(appRef)
+}
 
-	if len(rolePermissionIDs) <= 0 {
-		return false, nil
+// RoleIsAllowed checks if role roleID has permission permissionID, either
+// directly or through a role it inherits from
+func (permissions *Permissionist) RoleIsAllowed(roleID string, permissionID string) (bool, error) {
+	if err := checkUUIDs(roleID, permissionID); err != nil {
+		return false, errors.Wrap(err, "Could not check permission")
+	}
+	grants, err := permissions.roleGrants([]string{roleID}, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "Could not check permission")
+	}
+	index, err := permissions.permissionIndex(grants, []string{permissionID})
+	if err != nil {
+		return false, errors.Wrap(err, "Could not check permission")
 	}
 
-	return true, nil
+	return grants[roleID].allows(index[permissionID], index), nil
 }
 
 // GetApps returns a list of all apps
 func (permissions *Permissionist) GetApps() ([]App, error) {
-	var apps []App
-	err := permissions.DB.Select(&apps, `SELECT * FROM apps;`)
+	apps, err := permissions.Store.GetApps()
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not get apps")
 	}
@@ -100,19 +191,29 @@ func (permissions *Permissionist) GetApps() ([]App, error) {
 	return apps, nil
 }
 
+// ListApps returns a page of apps
+func (permissions *Permissionist) ListApps(opts ListOptions) (AppPage, error) {
+	query, err := opts.query()
+	if err != nil {
+		return AppPage{}, errors.Wrap(err, "Could not list apps")
+	}
+	apps, err := permissions.Store.ListApps(query)
+	if err != nil {
+		return AppPage{}, errors.Wrap(err, "Could not list apps")
+	}
+
+	page := AppPage{Apps: apps}
+	if query.more(len(apps)) {
+		page.Apps = apps[:query.PageSize]
+		last := page.Apps[query.PageSize-1]
+		page.NextPageToken = query.nextPageToken(last.Name, last.ID)
+	}
+	return page, nil
+}
+
 // GetAppsByEntityID returns a list of all apps
 func (permissions *Permissionist) GetAppsByEntityID(entityID string) ([]App, error) {
-	var apps []App
-	err := permissions.DB.Select(&apps, `
-	SELECT a.id, a.name
-	FROM apps AS a
-	INNER JOIN entity_roles AS er
-		ON er.entity_id = $1
-	INNER JOIN roles AS r
-		ON r.app_id = a.id
-			AND r.id = er.role_id;
-	`, entityID)
-
+	apps, err := permissions.Store.GetAppsByEntityID(entityID)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not get apps")
 	}
@@ -122,13 +223,7 @@ func (permissions *Permissionist) GetAppsByEntityID(entityID string) ([]App, err
 
 // GetApp returns an app by id
 func (permissions *Permissionist) GetApp(appID string) (App, error) {
-	var app App
-	err := permissions.DB.Get(&app, `
-	SELECT id, name
-	FROM apps
-	WHERE id = $1;
-	`, appID)
-
+	app, err := permissions.Store.GetApp(appID)
 	if err != nil {
 		return app, errors.Wrap(err, "Could not get app")
 	}
@@ -136,20 +231,60 @@ func (permissions *Permissionist) GetApp(appID string) (App, error) {
 	return app, nil
 }
 
-// GetPermissionsByEntityID returns a list of all permissions that belong to an entity
+// GetPermissionsByEntityID returns a list of all permissions in an app that
+// belong to an entity, including those inherited through role parents and
+// those covered by a wildcard permission
 func (permissions *Permissionist) GetPermissionsByEntityID(entityID string, appID string) ([]Permission, error) {
+	appPermissions, err := permissions.Store.GetPermissionsByAppID(appID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get permissions")
+	}
+	grants, err := permissions.entityGrants([]string{entityID}, Resource{}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get permissions")
+	}
+
+	index := map[string]Permission{}
+	for _, perm := range appPermissions {
+		index[perm.ID] = perm
+	}
+
 	var perms []Permission
-	err := permissions.DB.Select(&perms, `
-	SELECT p.id, p.name, p.app_id
-	FROM permissions AS p
-	INNER JOIN entity_roles AS er
-		ON er.entity_id = $1
-			AND p.app_id = $2
-	INNER JOIN role_permissions AS rp
-		ON er.role_id = rp.role_id 
-			AND rp.permission_id = p.id;
-	`, entityID, appID)
+	for _, perm := range appPermissions {
+		if grants[entityID].allows(perm, index) {
+			perms = append(perms, perm)
+		}
+	}
 
+	return perms, nil
+}
+
+// GetPermissionByName returns a permission by its name within an app
+func (permissions *Permissionist) GetPermissionByName(appID string, name string) (Permission, error) {
+	perm, err := permissions.Store.GetPermissionByName(appID, name)
+	if err != nil {
+		return perm, errors.Wrap(err, "Could not get permission")
+	}
+
+	return perm, nil
+}
+
+// GetPermission returns a permission by id
+func (permissions *Permissionist) GetPermission(permissionID string) (Permission, error) {
+	perms, err := permissions.Store.GetPermissions([]string{permissionID})
+	if err != nil {
+		return Permission{}, errors.Wrap(err, "Could not get permission")
+	}
+	if len(perms) == 0 {
+		return Permission{}, errors.Wrap(ErrNotFound, "Could not get permission")
+	}
+
+	return perms[0], nil
+}
+
+// GetPermissionsByAppID returns a list of all permissions created for an app
+func (permissions *Permissionist) GetPermissionsByAppID(appID string) ([]Permission, error) {
+	perms, err := permissions.Store.GetPermissionsByAppID(appID)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not get permissions")
 	}
@@ -157,17 +292,9 @@ func (permissions *Permissionist) GetPermissionsByEntityID(entityID string, appI
 	return perms, nil
 }
 
-// GetPermissionsByRoleID returns a list of all permissions that belong to an entity
+// GetPermissionsByRoleID returns a list of all permissions granted to a role
 func (permissions *Permissionist) GetPermissionsByRoleID(roleID string) ([]Permission, error) {
-	var perms []Permission
-	err := permissions.DB.Select(&perms, `
-	SELECT p.id, p.name, p.app_id
-	FROM permissions AS p
-	INNER JOIN role_permissions AS rp
-		ON p.id = rp.permission_id
-			AND rp.role_id = $1;
-	`, roleID)
-
+	perms, err := permissions.Store.GetPermissionsByRoleID(roleID, false)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not get permissions")
 	}
@@ -177,13 +304,7 @@ func (permissions *Permissionist) GetPermissionsByRoleID(roleID string) ([]Permi
 
 // GetRolesByAppID returns a list of all roles created for an app
 func (permissions *Permissionist) GetRolesByAppID(appID string) ([]Role, error) {
-	roles := []Role{}
-	err := permissions.DB.Select(&roles, `
-	SELECT *
-	FROM roles
-	WHERE app_id = $1;
-	`, appID)
-
+	roles, err := permissions.Store.GetRolesByAppID(appID)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not get roles")
 	}
@@ -191,15 +312,23 @@ func (permissions *Permissionist) GetRolesByAppID(appID string) ([]Role, error)
 	return roles, nil
 }
 
+// ListRolesByAppID returns a page of the roles created for an app
+func (permissions *Permissionist) ListRolesByAppID(appID string, opts ListOptions) (RolePage, error) {
+	query, err := opts.query()
+	if err != nil {
+		return RolePage{}, errors.Wrap(err, "Could not list roles")
+	}
+	roles, err := permissions.Store.ListRolesByAppID(appID, query)
+	if err != nil {
+		return RolePage{}, errors.Wrap(err, "Could not list roles")
+	}
+
+	return rolePage(roles, query), nil
+}
+
 // GetRoleByID returns a role name
 func (permissions *Permissionist) GetRoleByID(roleID string) (Role, error) {
-	var role Role
-	err := permissions.DB.Get(&role, `
-	SELECT id, name, app_id
-	FROM roles
-	WHERE id = $1;
-	`, roleID)
-
+	role, err := permissions.Store.GetRole(roleID)
 	if err != nil {
 		return role, errors.Wrap(err, "Could not get role")
 	}
@@ -207,47 +336,78 @@ func (permissions *Permissionist) GetRoleByID(roleID string) (Role, error) {
 	return role, nil
 }
 
-// GetRolesByEntityID returns roles by entity_id
+// GetRolesByEntityID returns the roles assigned to an entity, directly or
+// through the groups it belongs to
 func (permissions *Permissionist) GetRolesByEntityID(entityID string) ([]Role, error) {
-	var roles []Role
-	err := permissions.DB.Select(&roles, `
-	SELECT r.id, r.name, r.app_id
-	FROM roles AS r
-	INNER JOIN entity_roles AS er
-		ON r.id = er.role_id
-			AND er.entity_id = $1;
-	`, entityID)
-
+	roles, err := permissions.Store.GetRolesByEntityID(entityID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get role")
+	}
+	groupRoles, err := permissions.groupRoles([]string{entityID})
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get role")
+	}
+	seen := map[string]bool{}
+	for _, role := range roles {
+		seen[role.ID] = true
+	}
+	var roleIDs []string
+	for _, roleID := range groupRoles[entityID] {
+		if !seen[roleID] {
+			seen[roleID] = true
+			roleIDs = append(roleIDs, roleID)
+		}
+	}
+	inherited, err := permissions.Store.GetRoles(roleIDs)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not get role")
 	}
 
-	return roles, nil
+	return append(roles, inherited...), nil
 }
 
-// AssignRoleToEntity assigns role to entity
-func (permissions *Permissionist) AssignRoleToEntity(entityID string, roleID string) error {
-	_, err := permissions.DB.Exec(`
-	INSERT INTO entity_roles AS er (id, entity_id, role_id) VALUES (
-		$1, $2, $3 
-	);
-	`, uuid.NewV4().String(), entityID, roleID)
-
+// ListRolesByEntityID returns a page of the roles assigned to an entity,
+// directly or through the groups it belongs to
+func (permissions *Permissionist) ListRolesByEntityID(entityID string, opts ListOptions) (RolePage, error) {
+	query, err := opts.query()
 	if err != nil {
-		return errors.Wrap(err, "Could not assign role to entity")
+		return RolePage{}, errors.Wrap(err, "Could not list roles")
+	}
+	// an entity holds few roles, so they are paged after merging in the
+	// ones its groups hold
+	roles, err := permissions.GetRolesByEntityID(entityID)
+	if err != nil {
+		return RolePage{}, errors.Wrap(err, "Could not list roles")
 	}
 
-	return nil
+	return rolePage(pageRoles(roles, query), query), nil
 }
 
-// UnassignRoleFromEntity unassigns role from entity
-func (permissions *Permissionist) UnassignRoleFromEntity(entityID string, roleID string) error {
-	_, err := permissions.DB.Exec(`
-	DELETE FROM entity_roles 
-	WHERE entity_id = $1 
-	AND role_id = $2;
-	`, entityID, roleID)
+// rolePage trims the roles a store returned for query down to a page
+func rolePage(roles []Role, query ListQuery) RolePage {
+	page := RolePage{Roles: roles}
+	if query.more(len(roles)) {
+		page.Roles = roles[:query.PageSize]
+		last := page.Roles[query.PageSize-1]
+		page.NextPageToken = query.nextPageToken(last.Name, last.ID)
+	}
+	return page
+}
 
+// AssignRoleToEntity assigns role to entity on resource. Pass a zero
+// Resource to assign the role everywhere.
+func (permissions *Permissionist) AssignRoleToEntity(entityID string, roleID string, resource Resource) error {
+	return permissions.AssignTemporaryRoleToEntity(entityID, roleID, resource, Validity{})
+}
+
+// UnassignRoleFromEntity unassigns role from entity on resource. Only the
+// assignment made on exactly that resource is removed, and ErrNotFound is
+// returned if there is none.
+func (permissions *Permissionist) UnassignRoleFromEntity(entityID string, roleID string, resource Resource) error {
+	if err := resource.check(); err != nil {
+		return errors.Wrap(err, "Could not unassign role from entity")
+	}
+	err := permissions.Store.DeleteEntityRole(entityID, roleID, resource)
 	if err != nil {
 		return errors.Wrap(err, "Could not unassign role from entity")
 	}
@@ -257,12 +417,29 @@ func (permissions *Permissionist) UnassignRoleFromEntity(entityID string, roleID
 
 // AssignPermissionToRole assigns permission to role
 func (permissions *Permissionist) AssignPermissionToRole(roleID string, permissionID string) error {
-	_, err := permissions.DB.Exec(`
-	INSERT INTO role_permissions (id, role_id, permission_id) VALUES (
-		$1, $2, $3
-	);
-	`, uuid.NewV4().String(), roleID, permissionID)
+	return permissions.grantPermissionToRole(roleID, permissionID, "", Validity{})
+}
 
+// grantPermissionToRole assigns permission to role, restricted to where the
+// condition expression holds and to the period validity. An empty
+// expression or a zero validity leaves the grant unrestricted.
+func (permissions *Permissionist) grantPermissionToRole(roleID string, permissionID string, expression string, validity Validity) error {
+	if expression != "" {
+		if _, err := parseCondition(expression); err != nil {
+			return errors.Wrap(err, "Could not assign permission to role")
+		}
+	}
+	validity, err := validity.check()
+	if err != nil {
+		return errors.Wrap(err, "Could not assign permission to role")
+	}
+	err = permissions.Store.InsertRolePermission(RolePermission{
+		ID:           uuid.NewV4().String(),
+		RoledID:      roleID,
+		PermissionID: permissionID,
+		Condition:    expression,
+		Validity:     validity,
+	})
 	if err != nil {
 		return errors.Wrap(err, "Could not assign permission to role")
 	}
@@ -272,12 +449,7 @@ func (permissions *Permissionist) AssignPermissionToRole(roleID string, permissi
 
 // UnassignPermissionFromRole unassigns permission from role
 func (permissions *Permissionist) UnassignPermissionFromRole(roleID string, permissionID string) error {
-	_, err := permissions.DB.Exec(`
-	DELETE FROM roles_permissions 
-	WHERE role_id = $1 
-	AND permission_id = $2;
-	`, roleID, permissionID)
-
+	err := permissions.Store.DeleteRolePermission(roleID, permissionID, false)
 	if err != nil {
 		return errors.Wrap(err, "Could not unassign permission from role")
 	}
@@ -287,15 +459,26 @@ func (permissions *Permissionist) UnassignPermissionFromRole(roleID string, perm
 
 // CreateApp creates a new app in the database
 func (permissions *Permissionist) CreateApp(name string) (App, error) {
-	var app App
-	err := permissions.DB.QueryRow(`
-	INSERT INTO apps (id, name) VALUES (
-		$1, $2
-	) RETURNING id, name;
-	`, uuid.NewV4().String(), name).Scan(&app.ID, &app.Name)
-
+	app := App{
+		ID:   uuid.NewV4().String(),
+		Name: name,
+	}
+	err := permissions.Store.InsertApp(app)
 	if err != nil {
-		return app, errors.Wrap(err, "Could not create a new app")
+		return App{}, errors.Wrap(err, "Could not create a new app")
+	}
+
+	return app, nil
+}
+
+// RenameApp changes the name of an app
+func (permissions *Permissionist) RenameApp(appID string, name string) (App, error) {
+	if len(name) < 1 {
+		return App{}, errors.Wrap(ErrMissingField, "Missing app name")
+	}
+	app := App{ID: appID, Name: name}
+	if err := permissions.Store.UpdateApp(app); err != nil {
+		return App{}, errors.Wrap(err, "Could not rename app")
 	}
 
 	return app, nil
@@ -303,10 +486,7 @@ func (permissions *Permissionist) CreateApp(name string) (App, error) {
 
 // RemoveApp removes an app and all cascading records
 func (permissions *Permissionist) RemoveApp(appID string) error {
-	_, err := permissions.DB.Exec(`
-	DELETE FROM apps WHERE id = $1;
-	`, appID)
-
+	err := permissions.Store.DeleteApp(appID)
 	if err != nil {
 		return errors.Wrap(err, "Could not delete app")
 	}
@@ -318,52 +498,82 @@ func (permissions *Permissionist) RemoveApp(appID string) error {
 func (permissions *Permissionist) CreatePermission(permissionName string, appID string) (Permission, error) {
 	var p Permission
 	if len(permissionName) < 1 {
-		return p, errors.New("Missing permission name")
+		return p, errors.Wrap(ErrMissingField, "Missing permission name")
 	}
 	if len(appID) < 1 {
-		return p, errors.New("Missing app id")
+		return p, errors.Wrap(ErrMissingField, "Missing app id")
 	}
-	err := permissions.DB.QueryRow(`
-	INSERT INTO permissions (id, name, app_id) VALUES (
-		$1, $2, $3
-	) RETURNING id, name, app_id;
-	`, uuid.NewV4().String(), permissionName, appID).Scan(&p.ID, &p.Name, &p.AppID)
-
+	newPermissions, err := permissions.Store.InsertPermissions([]Permission{{
+		ID:    uuid.NewV4().String(),
+		Name:  permissionName,
+		AppID: appID,
+	}}, InsertStrict)
 	if err != nil {
 		return p, errors.Wrap(err, "Could not create a new permission")
 	}
 
-	return p, nil
+	return newPermissions[0], nil
 }
 
-// CreatePermissions creates new permissions in the database
+// CreatePermissions creates new permissions in the database, either all of
+// them or none
 func (permissions *Permissionist) CreatePermissions(permissionNames []string, appID string) ([]Permission, error) {
+	newPermissions, err := permissions.Store.InsertPermissions(newPermissionRows(permissionNames, appID), InsertStrict)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create new permissions")
+	}
+
+	return newPermissions, nil
+}
+
+// EnsurePermissions creates the permissions among permissionNames that an
+// app does not have yet, and returns only those. Running it again with the
+// same names creates nothing, which makes it safe for seeding.
+func (permissions *Permissionist) EnsurePermissions(permissionNames []string, appID string) ([]Permission, error) {
+	newPermissions, err := permissions.Store.InsertPermissions(newPermissionRows(permissionNames, appID), InsertSkipExisting)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not ensure permissions")
+	}
+
+	return newPermissions, nil
+}
+
+func newPermissionRows(permissionNames []string, appID string) []Permission {
 	var newPermissions []Permission
-	query := "INSERT INTO permissions (id, name, app_id) VALUES "
 	for _, permissionName := range permissionNames {
-		newPermission := Permission{
+		newPermissions = append(newPermissions, Permission{
 			ID:    uuid.NewV4().String(),
 			Name:  permissionName,
 			AppID: appID,
-		}
-		query += fmt.Sprintf(`('%s', '%s', '%s'),`, newPermission.ID, newPermission.Name, newPermission.AppID)
-		newPermissions = append(newPermissions, newPermission)
+		})
 	}
-	query = strings.TrimSuffix(query, ",") + ";"
-	_, err := permissions.DB.Exec(query)
+	return newPermissions
+}
+
+// RenamePermission changes the name of a permission within its app
+func (permissions *Permissionist) RenamePermission(permissionID string, name string) (Permission, error) {
+	if len(name) < 1 {
+		return Permission{}, errors.Wrap(ErrMissingField, "Missing permission name")
+	}
+	var perm Permission
+	err := permissions.Transact(func(tx *Permissionist) error {
+		if err := tx.Store.UpdatePermission(Permission{ID: permissionID, Name: name}); err != nil {
+			return err
+		}
+		var err error
+		perm, err = tx.GetPermission(permissionID)
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "Could not create new permissions")
+		return Permission{}, errors.Wrap(err, "Could not rename permission")
 	}
 
-	return newPermissions, nil
+	return perm, nil
 }
 
-// RemovePermission removes a role and all cascading records
+// RemovePermission removes a permission and all cascading records
 func (permissions *Permissionist) RemovePermission(permissionID string) error {
-	_, err := permissions.DB.Exec(`
-	DELETE FROM permissions WHERE id = $1;
-	`, permissionID)
-
+	err := permissions.Store.DeletePermission(permissionID)
 	if err != nil {
 		return errors.Wrap(err, "Could not delete permission")
 	}
@@ -373,48 +583,76 @@ func (permissions *Permissionist) RemovePermission(permissionID string) error {
 
 // CreateRole creates a new role in the database
 func (permissions *Permissionist) CreateRole(roleName string, appID string) (Role, error) {
-	var role Role
-	err := permissions.DB.QueryRow(`
-	INSERT INTO roles (id, name, app_id) VALUES (
-		$1, $2, $3
-	) RETURNING id, name, app_id;
-	`, uuid.NewV4().String(), roleName, appID).Scan(&role.ID, &role.Name, &role.AppID)
-
+	newRoles, err := permissions.Store.InsertRoles([]Role{{
+		ID:    uuid.NewV4().String(),
+		Name:  roleName,
+		AppID: appID,
+	}}, InsertStrict)
 	if err != nil {
-		return role, errors.Wrap(err, "Could not create a new role")
+		return Role{}, errors.Wrap(err, "Could not create a new role")
 	}
 
-	return role, nil
+	return newRoles[0], nil
 }
 
-// CreateRoles creates a new role in the database
+// CreateRoles creates new roles in the database, either all of them or none
 func (permissions *Permissionist) CreateRoles(roleNames []string, appID string) ([]Role, error) {
+	newRoles, err := permissions.Store.InsertRoles(newRoleRows(roleNames, appID), InsertStrict)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create a new role")
+	}
+
+	return newRoles, nil
+}
+
+// EnsureRoles creates the roles among roleNames that an app does not have
+// yet, and returns only those. Running it again with the same names creates
+// nothing, which makes it safe for seeding.
+func (permissions *Permissionist) EnsureRoles(roleNames []string, appID string) ([]Role, error) {
+	newRoles, err := permissions.Store.InsertRoles(newRoleRows(roleNames, appID), InsertSkipExisting)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not ensure roles")
+	}
+
+	return newRoles, nil
+}
+
+func newRoleRows(roleNames []string, appID string) []Role {
 	var newRoles []Role
-	query := "INSERT INTO roles (id, name, app_id) VALUES "
 	for _, roleName := range roleNames {
-		newRole := Role{
+		newRoles = append(newRoles, Role{
 			ID:    uuid.NewV4().String(),
 			Name:  roleName,
 			AppID: appID,
-		}
-		query += fmt.Sprintf(`('%s', '%s', '%s'),`, newRole.ID, newRole.Name, newRole.AppID)
-		newRoles = append(newRoles, newRole)
+		})
+	}
+	return newRoles
+}
+
+// RenameRole changes the name of a role within its app
+func (permissions *Permissionist) RenameRole(roleID string, name string) (Role, error) {
+	if len(name) < 1 {
+		return Role{}, errors.Wrap(ErrMissingField, "Missing role name")
 	}
-	query = strings.TrimSuffix(query, ",") + " RETURNING name;"
-	_, err := permissions.DB.Exec(query)
+	var role Role
+	err := permissions.Transact(func(tx *Permissionist) error {
+		if err := tx.Store.UpdateRole(Role{ID: roleID, Name: name}); err != nil {
+			return err
+		}
+		var err error
+		role, err = tx.Store.GetRole(roleID)
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "Could not create a new role")
+		return Role{}, errors.Wrap(err, "Could not rename role")
 	}
 
-	return newRoles, nil
+	return role, nil
 }
 
 // RemoveRole removes a role and all cascading records
 func (permissions *Permissionist) RemoveRole(roleID string) error {
-	_, err := permissions.DB.Exec(`
-	DELETE FROM roles WHERE id = $1;
-	`, roleID)
-
+	err := permissions.Store.DeleteRole(roleID)
 	if err != nil {
 		return errors.Wrap(err, "Could not delete role")
 	}
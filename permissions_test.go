@@ -1,11 +1,12 @@
 package main
 
 import (
-	"github.com/jmoiron/sqlx"
-	"github.com/spf13/viper"
-	"io/ioutil"
-	"log"
-	"os"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -21,16 +22,13 @@ func TestAssignPermissionToRole(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
-
-		err := P.AssignPermissionToRole(tc.RoleID, tc.PermissionID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
+			err := P.AssignPermissionToRole(tc.RoleID, tc.PermissionID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
 		}
 	}
 }
@@ -56,19 +54,16 @@ func TestEntityIsAllowed(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
-
-		allowed, err := P.EntityIsAllowed(tc.EntityID, tc.PermissionID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		if allowed != tc.Expected {
-			t.Errorf("Expected permission to be '%t' got '%t'", tc.Expected, allowed)
+			allowed, err := P.EntityIsAllowed(tc.EntityID, tc.PermissionID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			if allowed != tc.Expected {
+				t.Errorf("[%s] Expected permission to be '%t' got '%t'", backend, tc.Expected, allowed)
+			}
 		}
 	}
 }
@@ -95,19 +90,16 @@ func TestRoleIsAllowed(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
-
-		allowed, err := P.RoleIsAllowed(tc.RoleID, tc.PermissionID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		if allowed != tc.Expected {
-			t.Errorf("Expected permission to be '%t' got '%t'", tc.Expected, allowed)
+			allowed, err := P.RoleIsAllowed(tc.RoleID, tc.PermissionID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			if allowed != tc.Expected {
+				t.Errorf("[%s] Expected permission to be '%t' got '%t'", backend, tc.Expected, allowed)
+			}
 		}
 	}
 }
@@ -124,19 +116,16 @@ func TestCreateApp(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
-
-		newApp, err := P.CreateApp(tc.Name)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		if newApp.Name != tc.Expected {
-			t.Errorf("Expected app named %s got %s", tc.Expected, newApp.Name)
+			newApp, err := P.CreateApp(tc.Name)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			if newApp.Name != tc.Expected {
+				t.Errorf("[%s] Expected app named %s got %s", backend, tc.Expected, newApp.Name)
+			}
 		}
 	}
 }
@@ -153,19 +142,16 @@ func TestGetApp(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		app, err := P.GetApp(tc.AppID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		if app.ID != tc.Expected {
-			t.Errorf("Expected app %s got %s", tc.Expected, app.ID)
+			app, err := P.GetApp(tc.AppID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			if app.ID != tc.Expected {
+				t.Errorf("[%s] Expected app %s got %s", backend, tc.Expected, app.ID)
+			}
 		}
 	}
 }
@@ -181,27 +167,24 @@ func TestGetApps(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		apps, err := P.GetApps()
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		found := 0
-		for i := range apps {
-			for j := range tc.Expected {
-				if apps[i].ID == tc.Expected[j] {
-					found++
+			apps, err := P.GetApps()
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range apps {
+				for j := range tc.Expected {
+					if apps[i].ID == tc.Expected[j] {
+						found++
+					}
 				}
 			}
-		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d roles got %d", len(tc.Expected), found)
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d roles got %d", backend, len(tc.Expected), found)
+			}
 		}
 	}
 }
@@ -223,27 +206,24 @@ func TestGetPermissionsByEntityID(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		permissions, err := P.GetPermissionsByEntityID(tc.EntityID, tc.AppID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		found := 0
-		for i := range permissions {
-			for j := range tc.Expected {
-				if permissions[i].ID == tc.Expected[j] {
-					found++
+			permissions, err := P.GetPermissionsByEntityID(tc.EntityID, tc.AppID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range permissions {
+				for j := range tc.Expected {
+					if permissions[i].ID == tc.Expected[j] {
+						found++
+					}
 				}
 			}
-		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d permissions got %d", len(tc.Expected), found)
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d permissions got %d", backend, len(tc.Expected), found)
+			}
 		}
 	}
 }
@@ -260,19 +240,16 @@ func TestGetRoleByID(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
-
-		role, err := P.GetRoleByID(tc.RoleID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		if role.Name != tc.Expected {
-			t.Errorf("Expected role named %s but got %s", tc.Expected, role.Name)
+			role, err := P.GetRoleByID(tc.RoleID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			if role.Name != tc.Expected {
+				t.Errorf("[%s] Expected role named %s but got %s", backend, tc.Expected, role.Name)
+			}
 		}
 	}
 }
@@ -291,27 +268,24 @@ func TestGetRolesByAppID(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
-
-		roles, err := P.GetRolesByAppID(tc.AppID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		found := 0
-		for i := range roles {
-			for j := range tc.Expected {
-				if roles[i].ID == tc.Expected[j] {
-					found++
+			roles, err := P.GetRolesByAppID(tc.AppID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range roles {
+				for j := range tc.Expected {
+					if roles[i].ID == tc.Expected[j] {
+						found++
+					}
 				}
 			}
-		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d roles got %d", len(tc.Expected), found)
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d roles got %d", backend, len(tc.Expected), found)
+			}
 		}
 	}
 }
@@ -328,27 +302,24 @@ func TestGetRolesByEntityID(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		apps, err := P.GetRolesByEntityID(tc.EntityID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		found := 0
-		for i := range apps {
-			for j := range tc.Expected {
-				if apps[i].ID == tc.Expected[j] {
-					found++
+			apps, err := P.GetRolesByEntityID(tc.EntityID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range apps {
+				for j := range tc.Expected {
+					if apps[i].ID == tc.Expected[j] {
+						found++
+					}
 				}
 			}
-		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d roles got %d", len(tc.Expected), found)
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d roles got %d", backend, len(tc.Expected), found)
+			}
 		}
 	}
 }
@@ -365,27 +336,24 @@ func TestGetAppsByEntityID(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
-
-		apps, err := P.GetAppsByEntityID(tc.EntityID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		found := 0
-		for i := range apps {
-			for j := range tc.Expected {
-				if apps[i].ID == tc.Expected[j] {
-					found++
+			apps, err := P.GetAppsByEntityID(tc.EntityID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range apps {
+				for j := range tc.Expected {
+					if apps[i].ID == tc.Expected[j] {
+						found++
+					}
 				}
 			}
-		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d roles got %d", len(tc.Expected), found)
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d roles got %d", backend, len(tc.Expected), found)
+			}
 		}
 	}
 }
@@ -405,27 +373,24 @@ func TestGetPermissionsByRoleID(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		permissions, err := P.GetPermissionsByRoleID(tc.RoleID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		found := 0
-		for i := range permissions {
-			for j := range tc.Expected {
-				if permissions[i].ID == tc.Expected[j] {
-					found++
+			permissions, err := P.GetPermissionsByRoleID(tc.RoleID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range permissions {
+				for j := range tc.Expected {
+					if permissions[i].ID == tc.Expected[j] {
+						found++
+					}
 				}
 			}
-		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d roles got %d", len(tc.Expected), found)
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d roles got %d", backend, len(tc.Expected), found)
+			}
 		}
 	}
 }
@@ -449,19 +414,16 @@ func TestCreatePermission(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		newPermission, err := P.CreatePermission(tc.Permission, tc.AppID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		if newPermission.Name != tc.Expected {
-			t.Errorf("Expected permission name of '%s' got '%s'", tc.Expected, newPermission.Name)
+			newPermission, err := P.CreatePermission(tc.Permission, tc.AppID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			if newPermission.Name != tc.Expected {
+				t.Errorf("[%s] Expected permission name of '%s' got '%s'", backend, tc.Expected, newPermission.Name)
+			}
 		}
 	}
 }
@@ -483,27 +445,24 @@ func TestCreatePermissions(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		newPermissions, err := P.CreatePermissions(tc.Permissions, tc.AppID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		found := 0
-		for i := range newPermissions {
-			for j := range tc.Permissions {
-				if newPermissions[i].Name == tc.Permissions[j] {
-					found++
+			newPermissions, err := P.CreatePermissions(tc.Permissions, tc.AppID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range newPermissions {
+				for j := range tc.Permissions {
+					if newPermissions[i].Name == tc.Permissions[j] {
+						found++
+					}
 				}
 			}
-		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d roles got %d", len(tc.Permissions), found)
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d roles got %d", backend, len(tc.Permissions), found)
+			}
 		}
 	}
 }
@@ -522,16 +481,13 @@ func TestAssignRoleToEntity(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		err := P.AssignRoleToEntity(tc.EntityID, tc.RoleID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
+			err := P.AssignRoleToEntity(tc.EntityID, tc.RoleID, Resource{})
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
 		}
 	}
 }
@@ -553,19 +509,16 @@ func TestCreateRole(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
-
-		P := Permissionist{db}
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		newRole, err := P.CreateRole(tc.Role, tc.AppID)
-		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
-		}
-		if newRole.Name != tc.Expected {
-			t.Errorf("Expected permission name of '%s' got '%s'", tc.Expected, newRole.Name)
+			newRole, err := P.CreateRole(tc.Role, tc.AppID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			if newRole.Name != tc.Expected {
+				t.Errorf("[%s] Expected permission name of '%s' got '%s'", backend, tc.Expected, newRole.Name)
+			}
 		}
 	}
 }
@@ -587,77 +540,270 @@ func TestCreateRoles(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		config := testConfig()
-		db := testDb(config.GetString("database"))
-		testCleanup(db)
-		testMigrate(db)
+		for backend, store := range testStores() {
+			P := Permissionist{store}
 
-		P := Permissionist{db}
+			newRoles, err := P.CreateRoles(tc.Roles, tc.AppID)
+			if (err != nil) != tc.IsErr {
+				t.Errorf("[%s] Unexpected error response [%v]", backend, err)
+			}
+			found := 0
+			for i := range newRoles {
+				for j := range tc.Roles {
+					if newRoles[i].Name == tc.Roles[j] {
+						found++
+					}
+				}
+			}
+			if found != len(tc.Expected) {
+				t.Errorf("[%s] Expected %d roles got %d", backend, len(tc.Roles), found)
+			}
+		}
+	}
+}
 
-		newRoles, err := P.CreateRoles(tc.Roles, tc.AppID)
+func TestPermissionNameMatches(t *testing.T) {
+	var cases = []struct {
+		Pattern  string
+		Name     string
+		Expected bool
+	}{
+		{"read", "read", true},
+		{"read", "write", false},
+		{"documents:read", "documents:read", true},
+		{"documents:*", "documents:read", true},
+		{"documents:*", "documents:drafts:read", true},
+		{"documents:*", "documents", false},
+		{"documents:*", "images:read", false},
+		{"*", "documents:read", true},
+		{"*:read", "documents:read", true},
+		{"*:read", "documents:write", false},
+		{"*:read", "documents:drafts:read", false},
+		{"documents:read", "documents:*", false},
+	}
+
+	for _, tc := range cases {
+		if permissionNameMatches(tc.Pattern, tc.Name) != tc.Expected {
+			t.Errorf("Expected %q matching %q to be %v", tc.Pattern, tc.Name, tc.Expected)
+		}
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	context := Context{
+		"region":   "eu",
+		"priority": 3,
+		"caller": map[string]interface{}{
+			"region": "eu",
+			"teams":  []interface{}{"support", "billing"},
+		},
+	}
+	var cases = []struct {
+		Condition string
+		Expected  bool
+		IsErr     bool
+	}{
+		{"region == caller.region", true, false},
+		{"region == 'us'", false, false},
+		{"region != \"us\"", true, false},
+		{"priority > 2 && priority <= 3", true, false},
+		{"priority < 2 || \"support\" in caller.teams", true, false},
+		{"!(region == caller.region)", false, false},
+		{"!(missing == 'x')", false, false},
+		{"!(missing == 'x') || region == 'eu'", true, false},
+		{"!(missing == 'x' && region == 'us')", true, false},
+		{"!!(missing == 'x')", false, false},
+		{"\"sales\" in caller.teams", false, false},
+		{"missing == missing", false, false},
+		{"missing != 'eu'", false, false},
+		{"caller.region.country == 'eu'", false, false},
+		{"region", false, false},
+		{"true", true, false},
+		{"region ==", false, true},
+		{"(region == 'eu'", false, true},
+		{"region = 'eu'", false, true},
+		{"'eu", false, true},
+	}
+
+	for _, tc := range cases {
+		_, err := parseCondition(tc.Condition)
 		if (err != nil) != tc.IsErr {
-			t.Errorf("Unexpected error response [%v]", err)
+			t.Errorf("Unexpected error parsing %q [%v]", tc.Condition, err)
 		}
-		found := 0
-		for i := range newRoles {
-			for j := range tc.Roles {
-				if newRoles[i].Name == tc.Roles[j] {
-					found++
-				}
-			}
+		if evalCondition(tc.Condition, context) != tc.Expected {
+			t.Errorf("Expected %q to be %v", tc.Condition, tc.Expected)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	var cases = []struct {
+		Document string
+		Roles    int
+		IsErr    bool
+	}{
+		{"app: TacoApp\npermissions: [read]\nroles:\n  - name: admin\n    permissions: [read]\n", 1, false},
+		{`{"app": "TacoApp", "permissions": ["read"], "roles": [{"name": "admin", "permissions": ["read"]}]}`, 1, false},
+		{"app: TacoApp\n", 0, false},
+		{"permissions: [read]\n", 0, true},
+		{"app: TacoApp\npermissions: [read, read]\n", 0, true},
+		{"app: TacoApp\nroles:\n  - name: admin\n  - name: admin\n", 0, true},
+		{"app: TacoApp\nroles:\n  - name: admin\n    permissions: [write]\n", 0, true},
+		{"app: [TacoApp", 0, true},
+	}
+
+	for _, tc := range cases {
+		policy, err := ParsePolicy([]byte(tc.Document))
+		if (err != nil) != tc.IsErr {
+			t.Errorf("Unexpected error parsing %q [%v]", tc.Document, err)
 		}
-		if found != len(tc.Expected) {
-			t.Errorf("Expected %d roles got %d", len(tc.Roles), found)
+		if err == nil && len(policy.Roles) != tc.Roles {
+			t.Errorf("Expected %d roles parsing %q got %d", tc.Roles, tc.Document, len(policy.Roles))
 		}
 	}
 }
 
-func testCleanup(db *sqlx.DB) {
-	_, err := db.Exec(`
-		DROP TABLE IF EXISTS apps CASCADE;
-		DROP TABLE IF EXISTS permissions CASCADE;
-		DROP TABLE IF EXISTS role_permissions CASCADE;
-		DROP TABLE IF EXISTS roles CASCADE;
-		DROP TABLE IF EXISTS entity_roles CASCADE;
-	`)
-	if err != nil {
-		log.Fatal(err)
+func TestErrorCode(t *testing.T) {
+	var cases = []struct {
+		Err    error
+		Code   string
+		Status int
+		Reason string
+	}{
+		{errors.Wrap(ErrNotFound, "Could not get role"), CodeNotFound, 404, "not_found"},
+		{errors.Wrap(ErrMissingReference, "Could not assign role"), CodeNotFound, 404, "missing_reference"},
+		{errors.Wrap(ErrDuplicate, "Could not create app"), CodeConflict, 409, "duplicate"},
+		{ErrRoleCycle, CodeConflict, 409, "role_cycle"},
+		{errors.Wrap(ErrInvalidID, "Could not get app"), CodeInvalidArgument, 400, "invalid_id"},
+		{&BatchError{Index: 1, Op: "create_role", Err: ErrMissingField}, CodeInvalidArgument, 400, "missing_field"},
+		{errors.Wrap(ErrRelationDepth, "Could not check relation"), CodeInvalidArgument, 400, "relation_depth"},
+		{errors.New("connection refused"), CodeInternal, 500, ""},
+	}
+
+	for _, tc := range cases {
+		if ErrorCode(tc.Err) != tc.Code {
+			t.Errorf("Expected %v to have code %s got %s", tc.Err, tc.Code, ErrorCode(tc.Err))
+		}
+		response := errorResponse(tc.Err, "Could not do it")
+		if response.Reason != tc.Reason || codeStatuses[response.Code] != tc.Status {
+			t.Errorf("Expected %v to respond %d %s got %+v", tc.Err, tc.Status, tc.Reason, response)
+		}
+		if tc.Code == CodeInternal && response.Detail != "" {
+			t.Errorf("Expected internal error %v to carry no detail", tc.Err)
+		}
+		w := httptest.NewRecorder()
+		writeError(w, tc.Err, "Could not do it")
+		if w.Code != tc.Status || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Expected %v to write a %d JSON response got %d %q", tc.Err, tc.Status, w.Code, w.Header().Get("Content-Type"))
+		}
 	}
 }
 
-func testMigrate(db *sqlx.DB) {
-	schemas, err := ioutil.ReadFile("migrate.sql")
-	if err != nil {
-		log.Fatal(err)
+func TestWriteJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeJSON(w, Check{EntityID: "e", PermissionID: "p", Allowed: true})
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a 200 JSON response got %d %q", w.Code, w.Header().Get("Content-Type"))
 	}
-	_, err = db.Exec(string(schemas))
-	if err != nil {
-		log.Fatal(err)
+	var check Check
+	if err := json.Unmarshal(w.Body.Bytes(), &check); err != nil || !check.Allowed {
+		t.Errorf("Expected the check back got %s [%v]", w.Body.String(), err)
 	}
-	migrate, err := ioutil.ReadFile("seed.sql")
-	if err != nil {
-		log.Fatal(err)
+}
+
+func TestDecodeRequest(t *testing.T) {
+	appID := "a3b8e6c0-4f2b-4c1e-9d2a-7f6e5d4c3b2a"
+	var cases = []struct {
+		Req    request
+		Body   string
+		Reason string
+		Fields []string
+	}{
+		{&createAppRequest{}, `{"name": "app"}`, "", nil},
+		{&createAppRequest{}, `{}`, "invalid_request", []string{"name"}},
+		{&createAppRequest{}, `{"name": 7}`, "invalid_request", []string{"name"}},
+		{&createAppRequest{}, `{"name": "` + strings.Repeat("a", 61) + `"}`, "invalid_request", []string{"name"}},
+		{&createAppRequest{}, `{"name": "` + strings.Repeat("é", 60) + `"}`, "", nil},
+		{&createAppRequest{}, `{"name":`, "invalid_request", []string{""}},
+		{&createAppRequest{}, ``, "invalid_request", []string{""}},
+		{&createRoleRequest{AppID: appID}, `{"role_name": "admin"}`, "", nil},
+		{&createRoleRequest{AppID: "nope"}, `{}`, "invalid_request", []string{"app_id", "role_name"}},
+		{&createPermissionRequest{}, `{"name": "read", "app_id": "` + appID + `"}`, "", nil},
+		{&createPermissionRequest{}, `{"name": "read"}`, "invalid_request", []string{"app_id"}},
+		{&checksRequest{}, `{"checks": [{"entity_id": "e", "permission_id": "p"}]}`, "invalid_request", []string{"checks[0].permission_id"}},
+		{&relationTupleRequest{}, `{"namespace": "doc", "object_id": "1", "relation": "viewer", "subject_id": "e"}`, "", nil},
+		{&relationTupleRequest{}, `{"namespace": "doc", "object_id": "1", "relation": "viewer", "subject_id": "e", "subject_set": {"namespace": "group", "object_id": "2"}}`, "invalid_request", []string{"subject_id"}},
+		{&batchRequest{}, `{"operations": [{"op": "create_app"}, {"op": "drop_app"}]}`, "invalid_request", []string{"operations[1].op"}},
+		{&contextCheckRequest{entityRequest: entityRequest{EntityID: "e"}, PermissionID: appID}, `{"context": {}}`, "", nil},
+		{&contextCheckRequest{entityRequest: entityRequest{EntityID: strings.Repeat("e", 61)}, PermissionID: appID}, `{}`, "invalid_request", []string{"entity_id"}},
+		{&contextCheckRequest{entityRequest: entityRequest{EntityID: "e", Resource: Resource{"doc", strings.Repeat("1", 61)}}, PermissionID: appID}, `{}`, "invalid_request", []string{"resource_id"}},
+		{&createAppRequest{}, `{"name": "` + strings.Repeat("a", maxRequestBody) + `"}`, "request_too_large", nil},
 	}
-	_, err = db.Exec(string(migrate))
-	if err != nil {
-		log.Fatal(err)
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tc.Body))
+		err := decodeRequest(w, r, tc.Req)
+		if tc.Reason == "" {
+			if err != nil {
+				t.Errorf("Expected %s to decode got %v", tc.Body, err)
+			}
+			continue
+		}
+		response := errorResponse(err, "Could not process request")
+		if response.Reason != tc.Reason || codeStatuses[response.Code] != 400 {
+			t.Errorf("Expected %.80s to fail with %s got %+v", tc.Body, tc.Reason, response)
+			continue
+		}
+		var fields []string
+		for _, field := range response.Fields {
+			fields = append(fields, field.Field)
+		}
+		if strings.Join(fields, ",") != strings.Join(tc.Fields, ",") {
+			t.Errorf("Expected %s to fail on fields %v got %v", tc.Body, tc.Fields, response.Fields)
+		}
 	}
 }
 
-func testConfig() *viper.Viper {
-	config := viper.New()
-	config.SetConfigFile(os.Getenv("CONFIG"))
-	err := config.ReadInConfig()
-	if err != nil {
-		log.Fatal(err)
+func TestEntityFromRequest(t *testing.T) {
+	var cases = []struct {
+		URL    string
+		Fields []string
+	}{
+		{"/entities/e/roles", nil},
+		{"/entities/e/roles?resource_type=doc&resource_id=1", nil},
+		{"/entities/" + strings.Repeat("e", 61) + "/roles", []string{"entity_id"}},
+		{"/entities/e/roles?resource_type=" + strings.Repeat("d", 61), []string{"resource_type"}},
+	}
+
+	for _, tc := range cases {
+		var err error
+		router := mux.NewRouter()
+		router.HandleFunc("/entities/{entityID}/roles", func(w http.ResponseWriter, r *http.Request) {
+			_, err = entityFromRequest(r)
+		})
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.URL, nil))
+		if tc.Fields == nil {
+			if err != nil {
+				t.Errorf("Expected %.40s to be valid got %v", tc.URL, err)
+			}
+			continue
+		}
+		response := errorResponse(err, "Could not process request")
+		if response.Reason != "invalid_request" || len(response.Fields) != 1 || response.Fields[0].Field != tc.Fields[0] {
+			t.Errorf("Expected %.40s to fail on fields %v got %+v", tc.URL, tc.Fields, response)
+		}
 	}
-	return config
 }
 
-func testDb(database string) *sqlx.DB {
-	db, err := sqlx.Connect("postgres", database)
-	if err != nil {
-		log.Fatal(err)
+// testStores returns a store seeded with the rows in seed.sql for every
+// backend under test
+func testStores() map[string]Store {
+	stores := map[string]Store{}
+	for backend, newStore := range testBackends() {
+		store := newStore()
+		testSeed(store)
+		stores[backend] = store
 	}
-	return db
+	return stores
 }
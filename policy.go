@@ -0,0 +1,321 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidPolicy is returned for a policy document that does not parse or
+// contradicts itself
+var ErrInvalidPolicy = errors.New("Invalid policy")
+
+// Policy describes an app's roles, permissions and the permissions each
+// role is granted. It is written in YAML or JSON, for example
+//
+//	app: TacoApp
+//	permissions: [read, write]
+//	roles:
+//	  - name: admin
+//	    permissions: [read, write]
+//	  - name: customer
+//	    permissions: [read]
+//
+// Applying a policy makes the app match it: missing roles, permissions and
+// grants are created and ones the document leaves out are removed. Only
+// plain grants are managed; denials, conditional and time-bound grants are
+// left alone, though revoking a grant removes every grant of that
+// permission to that role.
+type Policy struct {
+	App         string       `json:"app" yaml:"app"`
+	Permissions []string     `json:"permissions" yaml:"permissions"`
+	Roles       []PolicyRole `json:"roles" yaml:"roles"`
+}
+
+// PolicyRole is a role in a Policy and the names of its permissions
+type PolicyRole struct {
+	Name        string   `json:"name" yaml:"name"`
+	Permissions []string `json:"permissions" yaml:"permissions"`
+}
+
+// PolicyGrant names a permission granted to a role in a PolicyPlan
+type PolicyGrant struct {
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+}
+
+// PolicyPlan lists the changes that would make an app match a Policy
+type PolicyPlan struct {
+	App               string        `json:"app"`
+	CreateApp         bool          `json:"create_app"`
+	CreatePermissions []string      `json:"create_permissions"`
+	RemovePermissions []Permission  `json:"remove_permissions"`
+	CreateRoles       []string      `json:"create_roles"`
+	RemoveRoles       []Role        `json:"remove_roles"`
+	Grants            []PolicyGrant `json:"grants"`
+	Revokes           []PolicyGrant `json:"revokes"`
+}
+
+// Empty checks if the plan changes nothing
+func (plan PolicyPlan) Empty() bool {
+	return !plan.CreateApp && len(plan.CreatePermissions) == 0 && len(plan.RemovePermissions) == 0 &&
+		len(plan.CreateRoles) == 0 && len(plan.RemoveRoles) == 0 && len(plan.Grants) == 0 && len(plan.Revokes) == 0
+}
+
+// ParsePolicy reads a policy document in YAML or JSON
+func ParsePolicy(document []byte) (Policy, error) {
+	var policy Policy
+	if err := yaml.Unmarshal(document, &policy); err != nil {
+		return policy, errors.Wrap(ErrInvalidPolicy, err.Error())
+	}
+	if err := policy.check(); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// check validates that names are given once and roles only reference
+// permissions the policy declares
+func (policy Policy) check() error {
+	if policy.App == "" {
+		return errors.Wrap(ErrInvalidPolicy, "Missing app")
+	}
+	permissionNames := map[string]bool{}
+	for _, name := range policy.Permissions {
+		if name == "" || permissionNames[name] {
+			return errors.Wrapf(ErrInvalidPolicy, "Bad or repeated permission %q", name)
+		}
+		permissionNames[name] = true
+	}
+	roleNames := map[string]bool{}
+	for _, role := range policy.Roles {
+		if role.Name == "" || roleNames[role.Name] {
+			return errors.Wrapf(ErrInvalidPolicy, "Bad or repeated role %q", role.Name)
+		}
+		roleNames[role.Name] = true
+		for _, name := range role.Permissions {
+			if !permissionNames[name] {
+				return errors.Wrapf(ErrInvalidPolicy, "Role %s is granted undeclared permission %s", role.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// PlanPolicy computes the changes ApplyPolicy would make, without making
+// them
+func (permissions *Permissionist) PlanPolicy(policy Policy) (PolicyPlan, error) {
+	if err := policy.check(); err != nil {
+		return PolicyPlan{}, errors.Wrap(err, "Could not plan policy")
+	}
+	plan, err := permissions.planPolicy(policy)
+	if err != nil {
+		return plan, errors.Wrap(err, "Could not plan policy")
+	}
+
+	return plan, nil
+}
+
+// ApplyPolicy makes the policy's app match it in a single transaction and
+// returns the changes made
+func (permissions *Permissionist) ApplyPolicy(policy Policy) (PolicyPlan, error) {
+	if err := policy.check(); err != nil {
+		return PolicyPlan{}, errors.Wrap(err, "Could not apply policy")
+	}
+	var plan PolicyPlan
+	err := permissions.Transact(func(tx *Permissionist) error {
+		var err error
+		if plan, err = tx.planPolicy(policy); err != nil {
+			return err
+		}
+		return tx.applyPlan(plan)
+	})
+	if err != nil {
+		return plan, errors.Wrap(err, "Could not apply policy")
+	}
+
+	return plan, nil
+}
+
+// planPolicy diffs policy against the store
+func (permissions *Permissionist) planPolicy(policy Policy) (PolicyPlan, error) {
+	plan := PolicyPlan{App: policy.App}
+	wanted := map[PolicyGrant]bool{}
+	for _, role := range policy.Roles {
+		for _, name := range role.Permissions {
+			wanted[PolicyGrant{role.Name, name}] = true
+		}
+	}
+
+	app, err := permissions.Store.GetAppByName(policy.App)
+	if errors.Cause(err) == ErrNotFound {
+		plan.CreateApp = true
+		plan.CreatePermissions = append(plan.CreatePermissions, policy.Permissions...)
+		for _, role := range policy.Roles {
+			plan.CreateRoles = append(plan.CreateRoles, role.Name)
+		}
+		for grant := range wanted {
+			plan.Grants = append(plan.Grants, grant)
+		}
+		sortPolicyGrants(plan.Grants)
+		return plan, nil
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	perms, err := permissions.Store.GetPermissionsByAppID(app.ID)
+	if err != nil {
+		return plan, err
+	}
+	permissionNames := map[string]string{}
+	existingPermissions := map[string]bool{}
+	for _, perm := range perms {
+		permissionNames[perm.ID] = perm.Name
+		existingPermissions[perm.Name] = true
+	}
+	keptPermissions := stringSet(policy.Permissions)
+	for _, name := range policy.Permissions {
+		if !existingPermissions[name] {
+			plan.CreatePermissions = append(plan.CreatePermissions, name)
+		}
+	}
+	for _, perm := range perms {
+		if !keptPermissions[perm.Name] {
+			plan.RemovePermissions = append(plan.RemovePermissions, perm)
+		}
+	}
+
+	roles, err := permissions.Store.GetRolesByAppID(app.ID)
+	if err != nil {
+		return plan, err
+	}
+	roleNames := map[string]string{}
+	existingRoles := map[string]bool{}
+	var roleIDs []string
+	for _, role := range roles {
+		roleNames[role.ID] = role.Name
+		existingRoles[role.Name] = true
+		roleIDs = append(roleIDs, role.ID)
+	}
+	keptRoles := map[string]bool{}
+	for _, role := range policy.Roles {
+		keptRoles[role.Name] = true
+		if !existingRoles[role.Name] {
+			plan.CreateRoles = append(plan.CreateRoles, role.Name)
+		}
+	}
+	for _, role := range roles {
+		if !keptRoles[role.Name] {
+			plan.RemoveRoles = append(plan.RemoveRoles, role)
+		}
+	}
+
+	rolePermissions, err := permissions.Store.GetRolePermissions(roleIDs)
+	if err != nil {
+		return plan, err
+	}
+	granted := map[PolicyGrant]bool{}
+	for _, rp := range rolePermissions {
+		if rp.Deny || rp.Condition != "" || rp.NotBefore != nil || rp.ExpiresAt != nil {
+			continue
+		}
+		permissionName, ok := permissionNames[rp.PermissionID]
+		if !ok {
+			continue
+		}
+		grant := PolicyGrant{roleNames[rp.RoledID], permissionName}
+		if granted[grant] {
+			continue
+		}
+		granted[grant] = true
+		// Grants of removed roles and permissions go with them
+		if !wanted[grant] && keptRoles[grant.Role] && keptPermissions[grant.Permission] {
+			plan.Revokes = append(plan.Revokes, grant)
+		}
+	}
+	for grant := range wanted {
+		if !granted[grant] {
+			plan.Grants = append(plan.Grants, grant)
+		}
+	}
+	sortPolicyGrants(plan.Grants)
+	sortPolicyGrants(plan.Revokes)
+	return plan, nil
+}
+
+// applyPlan makes the changes in plan
+func (permissions *Permissionist) applyPlan(plan PolicyPlan) error {
+	var app App
+	var err error
+	if plan.CreateApp {
+		app, err = permissions.CreateApp(plan.App)
+	} else {
+		app, err = permissions.Store.GetAppByName(plan.App)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, role := range plan.RemoveRoles {
+		if err := permissions.RemoveRole(role.ID); err != nil {
+			return err
+		}
+	}
+	for _, perm := range plan.RemovePermissions {
+		if err := permissions.RemovePermission(perm.ID); err != nil {
+			return err
+		}
+	}
+	if len(plan.CreatePermissions) > 0 {
+		if _, err := permissions.CreatePermissions(plan.CreatePermissions, app.ID); err != nil {
+			return err
+		}
+	}
+	if len(plan.CreateRoles) > 0 {
+		if _, err := permissions.CreateRoles(plan.CreateRoles, app.ID); err != nil {
+			return err
+		}
+	}
+	if len(plan.Grants) == 0 && len(plan.Revokes) == 0 {
+		return nil
+	}
+
+	perms, err := permissions.Store.GetPermissionsByAppID(app.ID)
+	if err != nil {
+		return err
+	}
+	permissionIDs := map[string]string{}
+	for _, perm := range perms {
+		permissionIDs[perm.Name] = perm.ID
+	}
+	roles, err := permissions.Store.GetRolesByAppID(app.ID)
+	if err != nil {
+		return err
+	}
+	roleIDs := map[string]string{}
+	for _, role := range roles {
+		roleIDs[role.Name] = role.ID
+	}
+	for _, grant := range plan.Revokes {
+		if err := permissions.UnassignPermissionFromRole(roleIDs[grant.Role], permissionIDs[grant.Permission]); err != nil {
+			return err
+		}
+	}
+	for _, grant := range plan.Grants {
+		if err := permissions.AssignPermissionToRole(roleIDs[grant.Role], permissionIDs[grant.Permission]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortPolicyGrants(grants []PolicyGrant) {
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].Role != grants[j].Role {
+			return grants[i].Role < grants[j].Role
+		}
+		return grants[i].Permission < grants[j].Permission
+	})
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the relation tuple API
+var (
+	ErrInvalidNamespace = errors.New("Invalid namespace configuration")
+	ErrInvalidTuple     = errors.New("Invalid relation tuple")
+	ErrRelationDepth    = errors.New("Relation check is nested too deeply")
+)
+
+// maxRelationDepth bounds how many rewrites and subject sets a check or
+// expand follows
+const maxRelationDepth = 32
+
+// Namespace configures the relations objects of one type can have, such as
+// doc or folder, and how each is rewritten. A relation always includes the
+// subjects of its own tuples; its Rewrite adds more.
+type Namespace struct {
+	Name      string             `json:"name"`
+	Relations map[string]Rewrite `json:"relations"`
+}
+
+// Rewrite widens a relation. With
+//
+//	"viewer": {"computed_usersets": ["editor"],
+//	           "tuple_to_usersets": [{"tupleset": "parent", "computed_userset": "viewer"}]}
+//
+// every editor of a doc is a viewer of it, and so is every viewer of the
+// objects related to the doc by parent, such as its folder.
+type Rewrite struct {
+	ComputedUsersets []string         `json:"computed_usersets,omitempty"`
+	TupleToUsersets  []TupleToUserset `json:"tuple_to_usersets,omitempty"`
+}
+
+// TupleToUserset follows the Tupleset relation of an object to other
+// objects and takes their ComputedUserset relation
+type TupleToUserset struct {
+	Tupleset        string `json:"tupleset"`
+	ComputedUserset string `json:"computed_userset"`
+}
+
+// SubjectSet is every subject with Relation to an object, written
+// namespace:object_id#relation. Tuples that a TupleToUserset follows point
+// at an object and leave Relation empty.
+type SubjectSet struct {
+	Namespace string `json:"namespace"`
+	ObjectID  string `json:"object_id"`
+	Relation  string `json:"relation,omitempty"`
+}
+
+// RelationTuple says that a subject has a relation to an object, written
+// namespace:object_id#relation@subject. The subject is either an entity,
+// SubjectID, or a SubjectSet.
+type RelationTuple struct {
+	Namespace  string      `json:"namespace"`
+	ObjectID   string      `json:"object_id"`
+	Relation   string      `json:"relation"`
+	SubjectID  string      `json:"subject_id,omitempty"`
+	SubjectSet *SubjectSet `json:"subject_set,omitempty"`
+}
+
+// String returns the subject set in namespace:object_id#relation form
+func (set SubjectSet) String() string {
+	if set.Relation == "" {
+		return set.Namespace + ":" + set.ObjectID
+	}
+	return set.Namespace + ":" + set.ObjectID + "#" + set.Relation
+}
+
+// String returns the tuple in namespace:object_id#relation@subject form
+func (tuple RelationTuple) String() string {
+	object := SubjectSet{tuple.Namespace, tuple.ObjectID, tuple.Relation}.String()
+	if tuple.SubjectSet != nil {
+		return object + "@" + tuple.SubjectSet.String()
+	}
+	return object + "@" + tuple.SubjectID
+}
+
+// check validates that the namespace only rewrites to relations it defines
+func (namespace Namespace) check() error {
+	if namespace.Name == "" {
+		return errors.Wrap(ErrInvalidNamespace, "Missing name")
+	}
+	for relation, rewrite := range namespace.Relations {
+		for _, computed := range rewrite.ComputedUsersets {
+			if _, ok := namespace.Relations[computed]; !ok {
+				return errors.Wrapf(ErrInvalidNamespace, "%s rewrites to unknown relation %s", relation, computed)
+			}
+		}
+		for _, ttu := range rewrite.TupleToUsersets {
+			if _, ok := namespace.Relations[ttu.Tupleset]; !ok {
+				return errors.Wrapf(ErrInvalidNamespace, "%s follows unknown relation %s", relation, ttu.Tupleset)
+			}
+			if ttu.ComputedUserset == "" {
+				return errors.Wrapf(ErrInvalidNamespace, "%s follows %s without a computed userset", relation, ttu.Tupleset)
+			}
+		}
+	}
+	return nil
+}
+
+// PutNamespace creates or replaces a namespace configuration
+func (permissions *Permissionist) PutNamespace(namespace Namespace) error {
+	if err := namespace.check(); err != nil {
+		return errors.Wrap(err, "Could not put namespace")
+	}
+	if namespace.Relations == nil {
+		namespace.Relations = map[string]Rewrite{}
+	}
+	if err := permissions.Store.PutNamespace(namespace); err != nil {
+		return errors.Wrap(err, "Could not put namespace")
+	}
+
+	return nil
+}
+
+// GetNamespace returns a namespace configuration by name
+func (permissions *Permissionist) GetNamespace(name string) (Namespace, error) {
+	namespace, err := permissions.Store.GetNamespace(name)
+	if err != nil {
+		return namespace, errors.Wrap(err, "Could not get namespace")
+	}
+
+	return namespace, nil
+}
+
+// WriteRelationTuple stores a relation tuple. Its namespace must be
+// configured and define its relation.
+func (permissions *Permissionist) WriteRelationTuple(tuple RelationTuple) error {
+	if err := permissions.checkTuple(tuple); err != nil {
+		return errors.Wrap(err, "Could not write relation tuple")
+	}
+	if err := permissions.Store.InsertRelationTuple(tuple); err != nil {
+		return errors.Wrap(err, "Could not write relation tuple")
+	}
+
+	return nil
+}
+
+// DeleteRelationTuple removes a relation tuple
+func (permissions *Permissionist) DeleteRelationTuple(tuple RelationTuple) error {
+	if err := permissions.Store.DeleteRelationTuple(tuple); err != nil {
+		return errors.Wrap(err, "Could not delete relation tuple")
+	}
+
+	return nil
+}
+
+// checkTuple validates tuple against its namespace
+func (permissions *Permissionist) checkTuple(tuple RelationTuple) error {
+	if tuple.ObjectID == "" || (tuple.SubjectID == "") == (tuple.SubjectSet == nil) {
+		return ErrInvalidTuple
+	}
+	if tuple.SubjectSet != nil && (tuple.SubjectSet.Namespace == "" || tuple.SubjectSet.ObjectID == "") {
+		return ErrInvalidTuple
+	}
+	namespace, err := permissions.Store.GetNamespace(tuple.Namespace)
+	if errors.Cause(err) == ErrNotFound {
+		return errors.Wrapf(ErrMissingReference, "Unknown namespace %s", tuple.Namespace)
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := namespace.Relations[tuple.Relation]; !ok {
+		return errors.Wrapf(ErrInvalidTuple, "Unknown relation %s", tuple.Relation)
+	}
+	return nil
+}
+
+// CheckRelation checks if entity subjectID has relation to the object, either
+// through a tuple naming it or through subject sets and the rewrites of
+// the object's namespace
+func (permissions *Permissionist) CheckRelation(namespace string, objectID string, relation string, subjectID string) (bool, error) {
+	walk := relationWalk{permissions: permissions, namespaces: map[string]Namespace{}, seen: map[string]bool{}}
+	allowed, err := walk.check(SubjectSet{namespace, objectID, relation}, subjectID, 0)
+	if err != nil {
+		return false, errors.Wrap(err, "Could not check relation")
+	}
+
+	return allowed, nil
+}
+
+// UsersetTree is the expansion of a subject set: the entities it names
+// directly and the subject sets it includes
+type UsersetTree struct {
+	Set      string        `json:"set"`
+	Subjects []string      `json:"subjects"`
+	Children []UsersetTree `json:"children"`
+}
+
+// ExpandRelation returns the tree of every subject with relation to the
+// object. A subject set reached twice is only expanded the first time.
+func (permissions *Permissionist) ExpandRelation(namespace string, objectID string, relation string) (UsersetTree, error) {
+	walk := relationWalk{permissions: permissions, namespaces: map[string]Namespace{}, seen: map[string]bool{}}
+	tree, err := walk.expand(SubjectSet{namespace, objectID, relation}, 0)
+	if err != nil {
+		return tree, errors.Wrap(err, "Could not expand relation")
+	}
+
+	return tree, nil
+}
+
+// relationWalk follows tuples and rewrites from one subject set, caching
+// namespaces and remembering the sets it has visited
+type relationWalk struct {
+	permissions *Permissionist
+	namespaces  map[string]Namespace
+	seen        map[string]bool
+}
+
+// rewrite returns the rewrite of set's relation; unconfigured relations
+// have none
+func (walk relationWalk) rewrite(set SubjectSet) (Rewrite, error) {
+	namespace, ok := walk.namespaces[set.Namespace]
+	if !ok {
+		var err error
+		namespace, err = walk.permissions.Store.GetNamespace(set.Namespace)
+		if err != nil && errors.Cause(err) != ErrNotFound {
+			return Rewrite{}, err
+		}
+		walk.namespaces[set.Namespace] = namespace
+	}
+	return namespace.Relations[set.Relation], nil
+}
+
+// step loads what set directly includes: its own tuples, the sets its
+// rewrite computes and the sets reached through its tuplesets
+func (walk relationWalk) step(set SubjectSet) ([]string, []SubjectSet, error) {
+	var subjectIDs []string
+	var sets []SubjectSet
+	tuples, err := walk.permissions.Store.GetRelationTuples(set.Namespace, set.ObjectID, set.Relation)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tuple := range tuples {
+		if tuple.SubjectSet != nil {
+			sets = append(sets, *tuple.SubjectSet)
+		} else {
+			subjectIDs = append(subjectIDs, tuple.SubjectID)
+		}
+	}
+	rewrite, err := walk.rewrite(set)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, computed := range rewrite.ComputedUsersets {
+		sets = append(sets, SubjectSet{set.Namespace, set.ObjectID, computed})
+	}
+	for _, ttu := range rewrite.TupleToUsersets {
+		tuples, err := walk.permissions.Store.GetRelationTuples(set.Namespace, set.ObjectID, ttu.Tupleset)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, tuple := range tuples {
+			if tuple.SubjectSet != nil {
+				sets = append(sets, SubjectSet{tuple.SubjectSet.Namespace, tuple.SubjectSet.ObjectID, ttu.ComputedUserset})
+			}
+		}
+	}
+	return subjectIDs, sets, nil
+}
+
+func (walk relationWalk) check(set SubjectSet, subjectID string, depth int) (bool, error) {
+	if depth > maxRelationDepth {
+		return false, ErrRelationDepth
+	}
+	if walk.seen[set.String()] {
+		return false, nil
+	}
+	walk.seen[set.String()] = true
+	subjectIDs, sets, err := walk.step(set)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range subjectIDs {
+		if id == subjectID {
+			return true, nil
+		}
+	}
+	for _, next := range sets {
+		allowed, err := walk.check(next, subjectID, depth+1)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+	return false, nil
+}
+
+func (walk relationWalk) expand(set SubjectSet, depth int) (UsersetTree, error) {
+	tree := UsersetTree{Set: set.String(), Subjects: []string{}, Children: []UsersetTree{}}
+	if depth > maxRelationDepth {
+		return tree, ErrRelationDepth
+	}
+	if walk.seen[set.String()] {
+		return tree, nil
+	}
+	walk.seen[set.String()] = true
+	subjectIDs, sets, err := walk.step(set)
+	if err != nil {
+		return tree, err
+	}
+	tree.Subjects = append(tree.Subjects, subjectIDs...)
+	for _, next := range sets {
+		child, err := walk.expand(next, depth+1)
+		if err != nil {
+			return tree, err
+		}
+		tree.Children = append(tree.Children, child)
+	}
+	return tree, nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// maxRequestBody bounds the size of a request body in bytes
+const maxRequestBody = 1 << 20
+
+// maxNameLength is the longest name the name columns hold
+const maxNameLength = 60
+
+// ErrRequestTooLarge is returned for a request body over maxRequestBody
+var ErrRequestTooLarge = errors.New("Request body is too large")
+
+// FieldError says what is wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a request. Its cause is
+// ErrInvalidRequest.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+	return "Invalid request: " + strings.Join(messages, ", ")
+}
+
+// Cause returns ErrInvalidRequest
+func (e *ValidationError) Cause() error {
+	return ErrInvalidRequest
+}
+
+// fieldErrors collects FieldErrors while a request is validated
+type fieldErrors []FieldError
+
+func (errs *fieldErrors) add(field string, message string) {
+	*errs = append(*errs, FieldError{field, message})
+}
+
+// required checks that value is set
+func (errs *fieldErrors) required(field string, value string) {
+	if value == "" {
+		errs.add(field, "is required")
+	}
+}
+
+// name checks that value is set and fits a name column
+func (errs *fieldErrors) name(field string, value string) {
+	errs.required(field, value)
+	errs.maxLength(field, value)
+}
+
+// maxLength checks that value fits a name column
+func (errs *fieldErrors) maxLength(field string, value string) {
+	if utf8.RuneCountInString(value) > maxNameLength {
+		errs.add(field, fmt.Sprintf("must be at most %d characters", maxNameLength))
+	}
+}
+
+// uuid checks that value is set and is a UUID
+func (errs *fieldErrors) uuid(field string, value string) {
+	if value == "" {
+		errs.add(field, "is required")
+	} else if _, err := uuid.FromString(value); err != nil {
+		errs.add(field, "must be a UUID")
+	}
+}
+
+// err returns a ValidationError for the collected errors, or nil
+func (errs fieldErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: errs}
+}
+
+// request is a decoded request body that can check itself
+type request interface {
+	validate(errs *fieldErrors)
+}
+
+// readRequest reads the request body, failing with ErrRequestTooLarge past
+// maxRequestBody
+func readRequest(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return nil, ErrRequestTooLarge
+		}
+		return nil, errors.Wrap(ErrInvalidRequest, err.Error())
+	}
+	return body, nil
+}
+
+// decodeRequest decodes the JSON request body into req and validates it.
+// Path variables should be set on req beforehand so they are validated too.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req request) error {
+	body, err := readRequest(w, r)
+	if err != nil {
+		return err
+	}
+	var errs fieldErrors
+	if err := json.Unmarshal(body, req); err != nil {
+		switch e := err.(type) {
+		case *json.UnmarshalTypeError:
+			errs.add(e.Field, "must be a "+e.Type.String()+" not a "+e.Value)
+		case *json.SyntaxError:
+			errs.add("", fmt.Sprintf("is not valid JSON at offset %d", e.Offset))
+		default:
+			if err == io.EOF || len(body) == 0 {
+				errs.add("", "is required")
+			} else {
+				errs.add("", err.Error())
+			}
+		}
+		return errs.err()
+	}
+	req.validate(&errs)
+	return errs.err()
+}
+
+// validationFields returns the field errors err carries, if any
+func validationFields(err error) []FieldError {
+	for err != nil {
+		if validation, ok := err.(*ValidationError); ok {
+			return validation.Fields
+		}
+		causer, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			return nil
+		}
+		err = causer.Cause()
+	}
+	return nil
+}
+
+// listOptionsFromQuery reads ListOptions from the page_size, page_token,
+// prefix and order_by query parameters
+func listOptionsFromQuery(r *http.Request) (ListOptions, error) {
+	query := r.URL.Query()
+	opts := ListOptions{
+		PageToken: query.Get("page_token"),
+		Prefix:    query.Get("prefix"),
+		OrderBy:   query.Get("order_by"),
+	}
+	var errs fieldErrors
+	if size := query.Get("page_size"); size != "" {
+		var err error
+		if opts.PageSize, err = strconv.Atoi(size); err != nil || opts.PageSize < 0 {
+			errs.add("page_size", "must be a number no less than 0")
+		}
+	}
+	switch strings.TrimPrefix(opts.OrderBy, "-") {
+	case "", "name", "id":
+	default:
+		errs.add("order_by", "must be name, id, -name or -id")
+	}
+	return opts, errs.err()
+}
+
+// entityRequest is the entityID path parameter of a request, along with
+// the resource_type and resource_id query parameters
+type entityRequest struct {
+	EntityID string
+	Resource Resource
+}
+
+func (req *entityRequest) validate(errs *fieldErrors) {
+	errs.name("entity_id", req.EntityID)
+	errs.maxLength("resource_type", req.Resource.Type)
+	errs.maxLength("resource_id", req.Resource.ID)
+}
+
+// entityFromRequest reads and validates the entity and resource a request
+// names in its path and query
+func entityFromRequest(r *http.Request) (entityRequest, error) {
+	req := entityRequest{EntityID: mux.Vars(r)["entityID"], Resource: resourceFromQuery(r)}
+	var errs fieldErrors
+	req.validate(&errs)
+	return req, errs.err()
+}
+
+type createAppRequest struct {
+	Name string `json:"name"`
+}
+
+func (req *createAppRequest) validate(errs *fieldErrors) {
+	errs.name("name", req.Name)
+}
+
+type renameAppRequest struct {
+	AppID string `json:"-"`
+	Name  string `json:"name"`
+}
+
+func (req *renameAppRequest) validate(errs *fieldErrors) {
+	errs.uuid("app_id", req.AppID)
+	errs.name("name", req.Name)
+}
+
+type createRoleRequest struct {
+	AppID    string `json:"-"`
+	RoleName string `json:"role_name"`
+}
+
+func (req *createRoleRequest) validate(errs *fieldErrors) {
+	errs.uuid("app_id", req.AppID)
+	errs.name("role_name", req.RoleName)
+}
+
+type renameRoleRequest struct {
+	RoleID   string `json:"-"`
+	RoleName string `json:"role_name"`
+}
+
+func (req *renameRoleRequest) validate(errs *fieldErrors) {
+	errs.uuid("role_id", req.RoleID)
+	errs.name("role_name", req.RoleName)
+}
+
+type createPermissionRequest struct {
+	AppID string `json:"app_id"`
+	Name  string `json:"name"`
+}
+
+func (req *createPermissionRequest) validate(errs *fieldErrors) {
+	errs.uuid("app_id", req.AppID)
+	errs.name("name", req.Name)
+}
+
+type renamePermissionRequest struct {
+	PermissionID string `json:"-"`
+	Name         string `json:"name"`
+}
+
+func (req *renamePermissionRequest) validate(errs *fieldErrors) {
+	errs.uuid("permission_id", req.PermissionID)
+	errs.name("name", req.Name)
+}
+
+type createGroupRequest struct {
+	AppID     string `json:"-"`
+	GroupName string `json:"group_name"`
+}
+
+func (req *createGroupRequest) validate(errs *fieldErrors) {
+	errs.uuid("app_id", req.AppID)
+	errs.name("group_name", req.GroupName)
+}
+
+type contextCheckRequest struct {
+	entityRequest `json:"-"`
+	PermissionID  string  `json:"-"`
+	Context       Context `json:"context"`
+}
+
+func (req *contextCheckRequest) validate(errs *fieldErrors) {
+	req.entityRequest.validate(errs)
+	errs.uuid("permission_id", req.PermissionID)
+}
+
+type checksRequest struct {
+	Checks []Check `json:"checks"`
+}
+
+func (req *checksRequest) validate(errs *fieldErrors) {
+	for i, check := range req.Checks {
+		errs.name(fmt.Sprintf("checks[%d].entity_id", i), check.EntityID)
+		errs.uuid(fmt.Sprintf("checks[%d].permission_id", i), check.PermissionID)
+	}
+}
+
+type putNamespaceRequest struct {
+	Name      string             `json:"-"`
+	Relations map[string]Rewrite `json:"relations"`
+}
+
+func (req *putNamespaceRequest) validate(errs *fieldErrors) {
+	errs.name("name", req.Name)
+}
+
+type relationTupleRequest struct {
+	RelationTuple
+}
+
+func (req *relationTupleRequest) validate(errs *fieldErrors) {
+	errs.name("namespace", req.Namespace)
+	errs.name("object_id", req.ObjectID)
+	errs.name("relation", req.Relation)
+	if req.SubjectSet == nil {
+		errs.name("subject_id", req.SubjectID)
+		return
+	}
+	if req.SubjectID != "" {
+		errs.add("subject_id", "must not be set with subject_set")
+	}
+	errs.name("subject_set.namespace", req.SubjectSet.Namespace)
+	errs.name("subject_set.object_id", req.SubjectSet.ObjectID)
+}
+
+type batchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+func (req *batchRequest) validate(errs *fieldErrors) {
+	for i, op := range req.Operations {
+		if _, ok := batchOperations[op.Op]; !ok {
+			errs.add(fmt.Sprintf("operations[%d].op", i), "is not a known operation")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidResource is returned for a resource with only one of its type
+// and id set
+var ErrInvalidResource = errors.New("Resource needs both a type and an id")
+
+// Resource identifies what a role assignment is scoped to, such as project
+// 42. The zero Resource stands for no particular resource.
+type Resource struct {
+	Type string `json:"resource_type,omitempty" db:"resource_type"`
+	ID   string `json:"resource_id,omitempty" db:"resource_id"`
+}
+
+// check validates that resource is either zero or fully set
+func (resource Resource) check() error {
+	if (resource.Type == "") != (resource.ID == "") {
+		return ErrInvalidResource
+	}
+	return nil
+}
+
+// resourceFromQuery reads the resource_type and resource_id query parameters
+func resourceFromQuery(r *http.Request) Resource {
+	return Resource{
+		Type: r.URL.Query().Get("resource_type"),
+		ID:   r.URL.Query().Get("resource_id"),
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// EntityRole entity_roles schema. An assignment with a zero Resource
+// applies everywhere; otherwise it only applies to that resource. Validity
+// bounds when it is in force.
+type EntityRole struct {
+	ID       string `json:"id" db:"id"`
+	EntityID string `json:"entity_id" db:"entity_id"`
+	RoleID   string `json:"role_id" db:"role_id"`
+	Resource
+	Validity
+}
+
+// RoleParent role_parents schema. The role inherits every permission of
+// its parent.
+type RoleParent struct {
+	ID       string `json:"id" db:"id"`
+	RoleID   string `json:"role_id" db:"role_id"`
+	ParentID string `json:"parent_id" db:"parent_id"`
+}
+
+// EntityDenial entity_denials schema. It denies a permission to an entity
+// whatever its roles grant.
+type EntityDenial struct {
+	ID           string `json:"id" db:"id"`
+	EntityID     string `json:"entity_id" db:"entity_id"`
+	PermissionID string `json:"permission_id" db:"permission_id"`
+}
+
+// GroupMember group_members schema
+type GroupMember struct {
+	ID       string `json:"id" db:"id"`
+	GroupID  string `json:"group_id" db:"group_id"`
+	EntityID string `json:"entity_id" db:"entity_id"`
+}
+
+// GroupParent group_parents schema. Every member of the group is also a
+// member of its parent.
+type GroupParent struct {
+	ID       string `json:"id" db:"id"`
+	GroupID  string `json:"group_id" db:"group_id"`
+	ParentID string `json:"parent_id" db:"parent_id"`
+}
+
+// GroupRole group_roles schema. Every member of the group holds the role.
+type GroupRole struct {
+	ID      string `json:"id" db:"id"`
+	GroupID string `json:"group_id" db:"group_id"`
+	RoleID  string `json:"role_id" db:"role_id"`
+}
+
+// relationTupleRow relation_tuples schema, with the empty string in the
+// subject columns a tuple does not use
+type relationTupleRow struct {
+	Namespace        string `db:"namespace"`
+	ObjectID         string `db:"object_id"`
+	Relation         string `db:"relation"`
+	SubjectID        string `db:"subject_id"`
+	SubjectNamespace string `db:"subject_namespace"`
+	SubjectObjectID  string `db:"subject_object_id"`
+	SubjectRelation  string `db:"subject_relation"`
+}
+
+// relationTupleToRow flattens a tuple into its row
+func relationTupleToRow(tuple RelationTuple) relationTupleRow {
+	row := relationTupleRow{
+		Namespace: tuple.Namespace,
+		ObjectID:  tuple.ObjectID,
+		Relation:  tuple.Relation,
+		SubjectID: tuple.SubjectID,
+	}
+	if tuple.SubjectSet != nil {
+		row.SubjectNamespace = tuple.SubjectSet.Namespace
+		row.SubjectObjectID = tuple.SubjectSet.ObjectID
+		row.SubjectRelation = tuple.SubjectSet.Relation
+	}
+	return row
+}
+
+// tuple converts the row back into a tuple
+func (row relationTupleRow) tuple() RelationTuple {
+	tuple := RelationTuple{
+		Namespace: row.Namespace,
+		ObjectID:  row.ObjectID,
+		Relation:  row.Relation,
+		SubjectID: row.SubjectID,
+	}
+	if row.SubjectNamespace != "" {
+		tuple.SubjectSet = &SubjectSet{row.SubjectNamespace, row.SubjectObjectID, row.SubjectRelation}
+	}
+	return tuple
+}
+
+// Store is the storage backend used by Permissionist. Implementations own
+// apps, roles, permissions, role_parents, role_permissions, entity_roles,
+// entity_denials, the entity_groups tables and the relation tuple tables,
+// and are expected to remove dependent records when an app, role,
+// permission or group is removed. Deciding what is allowed is left to
+// Permissionist; the lookups taking id lists let it do so in a fixed
+// number of calls.
+type Store interface {
+	// Transact runs fn against a store whose changes are kept only if fn
+	// returns nil. Calling Transact again inside fn joins the same
+	// transaction.
+	Transact(fn func(store Store) error) error
+
+	// apps
+	InsertApp(app App) error
+	GetApp(appID string) (App, error)
+	GetAppByName(name string) (App, error)
+	GetApps() ([]App, error)
+	GetAppsByEntityID(entityID string) ([]App, error)
+	ListApps(query ListQuery) ([]App, error)
+	UpdateApp(app App) error
+	DeleteApp(appID string) error
+
+	// roles
+	InsertRoles(roles []Role, mode InsertMode) ([]Role, error)
+	GetRole(roleID string) (Role, error)
+	GetRoles(roleIDs []string) ([]Role, error)
+	GetRolesByAppID(appID string) ([]Role, error)
+	GetRolesByEntityID(entityID string) ([]Role, error)
+	ListRolesByAppID(appID string, query ListQuery) ([]Role, error)
+	UpdateRole(role Role) error
+	DeleteRole(roleID string) error
+
+	// role_parents
+	InsertRoleParent(roleParent RoleParent) error
+	DeleteRoleParent(roleID string, parentID string) error
+	GetRoleParents(roleIDs []string) ([]RoleParent, error)
+	GetRoleChildren(parentIDs []string) ([]RoleParent, error)
+
+	// permissions
+	InsertPermissions(perms []Permission, mode InsertMode) ([]Permission, error)
+	GetPermissionByName(appID string, name string) (Permission, error)
+	GetPermissions(permissionIDs []string) ([]Permission, error)
+	GetPermissionsByAppID(appID string) ([]Permission, error)
+	GetPermissionsByRoleID(roleID string, deny bool) ([]Permission, error)
+	UpdatePermission(perm Permission) error
+	DeletePermission(permissionID string) error
+
+	// role_permissions
+	InsertRolePermission(rolePermission RolePermission) error
+	DeleteRolePermission(roleID string, permissionID string, deny bool) error
+	GetRolePermissions(roleIDs []string) ([]RolePermission, error)
+	GetRolePermissionsByPermissionIDs(permissionIDs []string) ([]RolePermission, error)
+	DeleteExpiredRolePermissions(before time.Time) ([]RolePermission, error)
+
+	// entity_roles
+	InsertEntityRole(entityRole EntityRole) error
+	DeleteEntityRole(entityID string, roleID string, resource Resource) error
+	GetEntityRoles(entityIDs []string) ([]EntityRole, error)
+	GetEntityRolesByRoleIDs(roleIDs []string) ([]EntityRole, error)
+	// ListEntityIDsByRoleIDs returns a page of the ids of the entities
+	// assigned any of roleIDs at time at, on any resource, or added
+	// directly to any of groupIDs. Entity ids serve as both name and id
+	// for query.
+	ListEntityIDsByRoleIDs(roleIDs []string, groupIDs []string, at time.Time, query ListQuery) ([]string, error)
+	DeleteExpiredEntityRoles(before time.Time) ([]EntityRole, error)
+
+	// entity_denials
+	InsertEntityDenial(entityDenial EntityDenial) error
+	DeleteEntityDenial(entityID string, permissionID string) error
+	GetEntityDenials(entityIDs []string) ([]EntityDenial, error)
+	GetEntityDenialsByPermissionIDs(permissionIDs []string) ([]EntityDenial, error)
+
+	// entity_groups
+	InsertGroup(group Group) error
+	GetGroup(groupID string) (Group, error)
+	GetGroupsByAppID(appID string) ([]Group, error)
+	DeleteGroup(groupID string) error
+
+	// group_members
+	InsertGroupMember(groupMember GroupMember) error
+	DeleteGroupMember(groupID string, entityID string) error
+	GetGroupMembers(groupID string) ([]GroupMember, error)
+	GetEntityGroups(entityIDs []string) ([]GroupMember, error)
+
+	// group_parents
+	InsertGroupParent(groupParent GroupParent) error
+	DeleteGroupParent(groupID string, parentID string) error
+	GetGroupParents(groupIDs []string) ([]GroupParent, error)
+	GetGroupChildren(parentIDs []string) ([]GroupParent, error)
+
+	// group_roles
+	InsertGroupRole(groupRole GroupRole) error
+	DeleteGroupRole(groupID string, roleID string) error
+	GetGroupRoles(groupIDs []string) ([]GroupRole, error)
+	GetGroupRolesByRoleIDs(roleIDs []string) ([]GroupRole, error)
+
+	// relation_namespaces
+	PutNamespace(namespace Namespace) error
+	GetNamespace(name string) (Namespace, error)
+
+	// relation_tuples
+	InsertRelationTuple(tuple RelationTuple) error
+	DeleteRelationTuple(tuple RelationTuple) error
+	GetRelationTuples(namespace string, objectID string, relation string) ([]RelationTuple, error)
+}
+
+// InsertMode says what InsertRoles and InsertPermissions do with a row
+// whose name is already taken in its app
+type InsertMode int
+
+const (
+	// InsertStrict fails the whole insert with ErrDuplicate
+	InsertStrict InsertMode = iota
+	// InsertSkipExisting leaves the row out and inserts the rest
+	InsertSkipExisting
+)
+
+// Errors returned by stores that enforce the migrate.sql constraints themselves
+var (
+	ErrNotFound         = errors.New("Record not found")
+	ErrDuplicate        = errors.New("Record violates a unique constraint")
+	ErrMissingReference = errors.New("Record references a missing record")
+	ErrInvalidID        = errors.New("Invalid UUID")
+)
+
+// checkUUIDs mirrors the UUID column type for stores without one
+func checkUUIDs(ids ...string) error {
+	for _, id := range ids {
+		if _, err := uuid.FromString(id); err != nil {
+			return errors.Wrapf(ErrInvalidID, "%q", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1410 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store. It enforces the same unique, foreign
+// key and cascade rules as migrate.sql and is safe for concurrent use.
+type MemoryStore struct {
+	mu              sync.RWMutex
+	apps            map[string]App
+	roles           map[string]Role
+	permissions     map[string]Permission
+	roleParents     map[string]RoleParent
+	rolePermissions map[string]RolePermission
+	entityRoles     map[string]EntityRole
+	entityDenials   map[string]EntityDenial
+	groups          map[string]Group
+	groupMembers    map[string]GroupMember
+	groupParents    map[string]GroupParent
+	groupRoles      map[string]GroupRole
+	namespaces      map[string]Namespace
+	relationTuples  map[relationTupleRow]bool
+	inTransaction   bool
+}
+
+// NewMemoryStore is a factory for MemoryStore structs
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		apps:            map[string]App{},
+		roles:           map[string]Role{},
+		permissions:     map[string]Permission{},
+		roleParents:     map[string]RoleParent{},
+		rolePermissions: map[string]RolePermission{},
+		entityRoles:     map[string]EntityRole{},
+		entityDenials:   map[string]EntityDenial{},
+		groups:          map[string]Group{},
+		groupMembers:    map[string]GroupMember{},
+		groupParents:    map[string]GroupParent{},
+		groupRoles:      map[string]GroupRole{},
+		namespaces:      map[string]Namespace{},
+		relationTuples:  map[relationTupleRow]bool{},
+	}
+}
+
+// Transact runs fn against a copy of the store and keeps the copy if fn
+// succeeds. Other callers wait until the transaction ends.
+func (store *MemoryStore) Transact(fn func(store Store) error) error {
+	if store.inTransaction {
+		return fn(store)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	tx := store.clone()
+	tx.inTransaction = true
+	if err := fn(tx); err != nil {
+		return err
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	store.apps = tx.apps
+	store.roles = tx.roles
+	store.permissions = tx.permissions
+	store.roleParents = tx.roleParents
+	store.rolePermissions = tx.rolePermissions
+	store.entityRoles = tx.entityRoles
+	store.entityDenials = tx.entityDenials
+	store.groups = tx.groups
+	store.groupMembers = tx.groupMembers
+	store.groupParents = tx.groupParents
+	store.groupRoles = tx.groupRoles
+	store.namespaces = tx.namespaces
+	store.relationTuples = tx.relationTuples
+	return nil
+}
+
+// clone copies the store's tables. The caller must hold the lock.
+func (store *MemoryStore) clone() *MemoryStore {
+	tx := NewMemoryStore()
+	for k, v := range store.apps {
+		tx.apps[k] = v
+	}
+	for k, v := range store.roles {
+		tx.roles[k] = v
+	}
+	for k, v := range store.permissions {
+		tx.permissions[k] = v
+	}
+	for k, v := range store.roleParents {
+		tx.roleParents[k] = v
+	}
+	for k, v := range store.rolePermissions {
+		tx.rolePermissions[k] = v
+	}
+	for k, v := range store.entityRoles {
+		tx.entityRoles[k] = v
+	}
+	for k, v := range store.entityDenials {
+		tx.entityDenials[k] = v
+	}
+	for k, v := range store.groups {
+		tx.groups[k] = v
+	}
+	for k, v := range store.groupMembers {
+		tx.groupMembers[k] = v
+	}
+	for k, v := range store.groupParents {
+		tx.groupParents[k] = v
+	}
+	for k, v := range store.groupRoles {
+		tx.groupRoles[k] = v
+	}
+	for k, v := range store.namespaces {
+		tx.namespaces[k] = v
+	}
+	for k, v := range store.relationTuples {
+		tx.relationTuples[k] = v
+	}
+	return tx
+}
+
+// InsertApp inserts an app
+func (store *MemoryStore) InsertApp(app App) error {
+	if err := checkUUIDs(app.ID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.apps[app.ID]; ok {
+		return ErrDuplicate
+	}
+	for _, a := range store.apps {
+		if a.Name == app.Name {
+			return ErrDuplicate
+		}
+	}
+	store.apps[app.ID] = app
+	return nil
+}
+
+// GetApp returns an app by id
+func (store *MemoryStore) GetApp(appID string) (App, error) {
+	if err := checkUUIDs(appID); err != nil {
+		return App{}, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	app, ok := store.apps[appID]
+	if !ok {
+		return App{}, ErrNotFound
+	}
+	return app, nil
+}
+
+// GetAppByName returns an app by name
+func (store *MemoryStore) GetAppByName(name string) (App, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, app := range store.apps {
+		if app.Name == name {
+			return app, nil
+		}
+	}
+	return App{}, ErrNotFound
+}
+
+// GetApps returns a list of all apps
+func (store *MemoryStore) GetApps() ([]App, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var apps []App
+	for _, app := range store.apps {
+		apps = append(apps, app)
+	}
+	sortApps(apps)
+	return apps, nil
+}
+
+// ListApps returns a page of apps
+func (store *MemoryStore) ListApps(query ListQuery) ([]App, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var apps []App
+	for _, app := range store.apps {
+		apps = append(apps, app)
+	}
+	return pageApps(apps, query), nil
+}
+
+// GetAppsByEntityID returns a list of all apps an entity has a role in
+func (store *MemoryStore) GetAppsByEntityID(entityID string) ([]App, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var apps []App
+	seen := map[string]bool{}
+	for _, er := range store.entityRoles {
+		appID := store.roles[er.RoleID].AppID
+		if er.EntityID != entityID || seen[appID] {
+			continue
+		}
+		seen[appID] = true
+		apps = append(apps, store.apps[appID])
+	}
+	sortApps(apps)
+	return apps, nil
+}
+
+// UpdateApp renames an app
+func (store *MemoryStore) UpdateApp(app App) error {
+	if err := checkUUIDs(app.ID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.apps[app.ID]; !ok {
+		return ErrNotFound
+	}
+	for id, a := range store.apps {
+		if id != app.ID && a.Name == app.Name {
+			return ErrDuplicate
+		}
+	}
+	store.apps[app.ID] = app
+	return nil
+}
+
+// DeleteApp deletes an app and all cascading records, failing with
+// ErrNotFound if there is none
+func (store *MemoryStore) DeleteApp(appID string) error {
+	if err := checkUUIDs(appID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.apps[appID]; !ok {
+		return ErrNotFound
+	}
+	for id, role := range store.roles {
+		if role.AppID == appID {
+			store.deleteRole(id)
+		}
+	}
+	for id, perm := range store.permissions {
+		if perm.AppID == appID {
+			store.deletePermission(id)
+		}
+	}
+	for id, group := range store.groups {
+		if group.AppID == appID {
+			store.deleteGroup(id)
+		}
+	}
+	delete(store.apps, appID)
+	return nil
+}
+
+// InsertRoles inserts roles, either all of them or none, and returns the
+// ones inserted. In InsertSkipExisting mode roles whose name is taken are
+// left out instead.
+func (store *MemoryStore) InsertRoles(roles []Role, mode InsertMode) ([]Role, error) {
+	for _, role := range roles {
+		if err := checkUUIDs(role.ID, role.AppID); err != nil {
+			return nil, err
+		}
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	taken := map[string]bool{}
+	for _, existing := range store.roles {
+		taken[existing.AppID+"\x00"+existing.Name] = true
+	}
+	var inserted []Role
+	for _, role := range roles {
+		if _, ok := store.apps[role.AppID]; !ok {
+			return nil, ErrMissingReference
+		}
+		if _, ok := store.roles[role.ID]; ok {
+			return nil, ErrDuplicate
+		}
+		if taken[role.AppID+"\x00"+role.Name] {
+			if mode == InsertSkipExisting {
+				continue
+			}
+			return nil, ErrDuplicate
+		}
+		taken[role.AppID+"\x00"+role.Name] = true
+		inserted = append(inserted, role)
+	}
+	for _, role := range inserted {
+		store.roles[role.ID] = role
+	}
+	return inserted, nil
+}
+
+// GetRole returns a role by id
+func (store *MemoryStore) GetRole(roleID string) (Role, error) {
+	if err := checkUUIDs(roleID); err != nil {
+		return Role{}, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	role, ok := store.roles[roleID]
+	if !ok {
+		return Role{}, ErrNotFound
+	}
+	return role, nil
+}
+
+// GetRoles returns the roles with ids roleIDs, skipping any that do not
+// exist
+func (store *MemoryStore) GetRoles(roleIDs []string) ([]Role, error) {
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	roles := []Role{}
+	for _, roleID := range roleIDs {
+		if role, ok := store.roles[roleID]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// GetRolesByAppID returns a list of all roles created for an app
+func (store *MemoryStore) GetRolesByAppID(appID string) ([]Role, error) {
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	roles := []Role{}
+	for _, role := range store.roles {
+		if role.AppID == appID {
+			roles = append(roles, role)
+		}
+	}
+	sortRoles(roles)
+	return roles, nil
+}
+
+// ListRolesByAppID returns a page of the roles created for an app
+func (store *MemoryStore) ListRolesByAppID(appID string, query ListQuery) ([]Role, error) {
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var roles []Role
+	for _, role := range store.roles {
+		if role.AppID == appID {
+			roles = append(roles, role)
+		}
+	}
+	return pageRoles(roles, query), nil
+}
+
+// GetRolesByEntityID returns roles by entity_id, whatever resource they
+// are assigned on
+func (store *MemoryStore) GetRolesByEntityID(entityID string) ([]Role, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var roles []Role
+	seen := map[string]bool{}
+	for _, er := range store.entityRoles {
+		if er.EntityID == entityID && !seen[er.RoleID] {
+			seen[er.RoleID] = true
+			roles = append(roles, store.roles[er.RoleID])
+		}
+	}
+	sortRoles(roles)
+	return roles, nil
+}
+
+// UpdateRole renames a role. A role cannot move to another app.
+func (store *MemoryStore) UpdateRole(role Role) error {
+	if err := checkUUIDs(role.ID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	existing, ok := store.roles[role.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for id, r := range store.roles {
+		if id != role.ID && r.AppID == existing.AppID && r.Name == role.Name {
+			return ErrDuplicate
+		}
+	}
+	existing.Name = role.Name
+	store.roles[role.ID] = existing
+	return nil
+}
+
+// DeleteRole deletes a role and all cascading records, failing with
+// ErrNotFound if there is none
+func (store *MemoryStore) DeleteRole(roleID string) error {
+	if err := checkUUIDs(roleID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.roles[roleID]; !ok {
+		return ErrNotFound
+	}
+	store.deleteRole(roleID)
+	return nil
+}
+
+func (store *MemoryStore) deleteRole(roleID string) {
+	for id, rp := range store.roleParents {
+		if rp.RoleID == roleID || rp.ParentID == roleID {
+			delete(store.roleParents, id)
+		}
+	}
+	for id, rp := range store.rolePermissions {
+		if rp.RoledID == roleID {
+			delete(store.rolePermissions, id)
+		}
+	}
+	for id, er := range store.entityRoles {
+		if er.RoleID == roleID {
+			delete(store.entityRoles, id)
+		}
+	}
+	for id, gr := range store.groupRoles {
+		if gr.RoleID == roleID {
+			delete(store.groupRoles, id)
+		}
+	}
+	delete(store.roles, roleID)
+}
+
+// InsertRoleParent makes a role inherit from a parent role
+func (store *MemoryStore) InsertRoleParent(roleParent RoleParent) error {
+	if err := checkUUIDs(roleParent.ID, roleParent.RoleID, roleParent.ParentID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.roleParents[roleParent.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := store.roles[roleParent.RoleID]; !ok {
+		return ErrMissingReference
+	}
+	if _, ok := store.roles[roleParent.ParentID]; !ok {
+		return ErrMissingReference
+	}
+	for _, rp := range store.roleParents {
+		if rp.RoleID == roleParent.RoleID && rp.ParentID == roleParent.ParentID {
+			return ErrDuplicate
+		}
+	}
+	store.roleParents[roleParent.ID] = roleParent
+	return nil
+}
+
+// DeleteRoleParent stops a role inheriting from a parent role, failing
+// with ErrNotFound if it does not
+func (store *MemoryStore) DeleteRoleParent(roleID string, parentID string) error {
+	if err := checkUUIDs(roleID, parentID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, rp := range store.roleParents {
+		if rp.RoleID == roleID && rp.ParentID == parentID {
+			delete(store.roleParents, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetRoleParents returns the parent links of roles roleIDs
+func (store *MemoryStore) GetRoleParents(roleIDs []string) ([]RoleParent, error) {
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(roleIDs)
+	var parents []RoleParent
+	for _, rp := range store.roleParents {
+		if wanted[rp.RoleID] {
+			parents = append(parents, rp)
+		}
+	}
+	return parents, nil
+}
+
+// GetRoleChildren returns the links of the roles that inherit directly from parentIDs
+func (store *MemoryStore) GetRoleChildren(parentIDs []string) ([]RoleParent, error) {
+	if err := checkUUIDs(parentIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(parentIDs)
+	var parents []RoleParent
+	for _, rp := range store.roleParents {
+		if wanted[rp.ParentID] {
+			parents = append(parents, rp)
+		}
+	}
+	return parents, nil
+}
+
+// InsertPermissions inserts permissions, either all of them or none, and returns the
+// ones inserted. In InsertSkipExisting mode permissions whose name is taken are
+// left out instead.
+func (store *MemoryStore) InsertPermissions(perms []Permission, mode InsertMode) ([]Permission, error) {
+	for _, perm := range perms {
+		if err := checkUUIDs(perm.ID, perm.AppID); err != nil {
+			return nil, err
+		}
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	taken := map[string]bool{}
+	for _, existing := range store.permissions {
+		taken[existing.AppID+"\x00"+existing.Name] = true
+	}
+	var inserted []Permission
+	for _, perm := range perms {
+		if _, ok := store.apps[perm.AppID]; !ok {
+			return nil, ErrMissingReference
+		}
+		if _, ok := store.permissions[perm.ID]; ok {
+			return nil, ErrDuplicate
+		}
+		if taken[perm.AppID+"\x00"+perm.Name] {
+			if mode == InsertSkipExisting {
+				continue
+			}
+			return nil, ErrDuplicate
+		}
+		taken[perm.AppID+"\x00"+perm.Name] = true
+		inserted = append(inserted, perm)
+	}
+	for _, perm := range inserted {
+		store.permissions[perm.ID] = perm
+	}
+	return inserted, nil
+}
+
+// GetPermissionByName returns a permission by its name within an app
+func (store *MemoryStore) GetPermissionByName(appID string, name string) (Permission, error) {
+	if err := checkUUIDs(appID); err != nil {
+		return Permission{}, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, perm := range store.permissions {
+		if perm.AppID == appID && perm.Name == name {
+			return perm, nil
+		}
+	}
+	return Permission{}, ErrNotFound
+}
+
+// GetPermissions returns the permissions with ids permissionIDs, skipping
+// any that do not exist
+func (store *MemoryStore) GetPermissions(permissionIDs []string) ([]Permission, error) {
+	if err := checkUUIDs(permissionIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var perms []Permission
+	for _, permissionID := range permissionIDs {
+		if perm, ok := store.permissions[permissionID]; ok {
+			perms = append(perms, perm)
+		}
+	}
+	return perms, nil
+}
+
+// GetPermissionsByAppID returns a list of all permissions created for an app
+func (store *MemoryStore) GetPermissionsByAppID(appID string) ([]Permission, error) {
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	perms := []Permission{}
+	for _, perm := range store.permissions {
+		if perm.AppID == appID {
+			perms = append(perms, perm)
+		}
+	}
+	sortPermissions(perms)
+	return perms, nil
+}
+
+// GetPermissionsByRoleID returns a list of all permissions granted to, or
+// with deny set denied to, a role
+func (store *MemoryStore) GetPermissionsByRoleID(roleID string, deny bool) ([]Permission, error) {
+	if err := checkUUIDs(roleID); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var perms []Permission
+	for _, rp := range store.rolePermissions {
+		if rp.RoledID == roleID && rp.Deny == deny {
+			perms = append(perms, store.permissions[rp.PermissionID])
+		}
+	}
+	sortPermissions(perms)
+	return perms, nil
+}
+
+// UpdatePermission renames a permission. A permission cannot move to
+// another app.
+func (store *MemoryStore) UpdatePermission(perm Permission) error {
+	if err := checkUUIDs(perm.ID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	existing, ok := store.permissions[perm.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for id, p := range store.permissions {
+		if id != perm.ID && p.AppID == existing.AppID && p.Name == perm.Name {
+			return ErrDuplicate
+		}
+	}
+	existing.Name = perm.Name
+	store.permissions[perm.ID] = existing
+	return nil
+}
+
+// DeletePermission deletes a permission and all cascading records, failing
+// with ErrNotFound if there is none
+func (store *MemoryStore) DeletePermission(permissionID string) error {
+	if err := checkUUIDs(permissionID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.permissions[permissionID]; !ok {
+		return ErrNotFound
+	}
+	store.deletePermission(permissionID)
+	return nil
+}
+
+func (store *MemoryStore) deletePermission(permissionID string) {
+	for id, rp := range store.rolePermissions {
+		if rp.PermissionID == permissionID {
+			delete(store.rolePermissions, id)
+		}
+	}
+	for id, ed := range store.entityDenials {
+		if ed.PermissionID == permissionID {
+			delete(store.entityDenials, id)
+		}
+	}
+	delete(store.permissions, permissionID)
+}
+
+// InsertRolePermission grants or denies a permission to a role
+func (store *MemoryStore) InsertRolePermission(rolePermission RolePermission) error {
+	if err := checkUUIDs(rolePermission.ID, rolePermission.RoledID, rolePermission.PermissionID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.rolePermissions[rolePermission.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := store.roles[rolePermission.RoledID]; !ok {
+		return ErrMissingReference
+	}
+	if _, ok := store.permissions[rolePermission.PermissionID]; !ok {
+		return ErrMissingReference
+	}
+	store.rolePermissions[rolePermission.ID] = rolePermission
+	return nil
+}
+
+// DeleteRolePermission removes a role's grant of, or with deny set its
+// denial of, a permission, failing with ErrNotFound if there is none
+func (store *MemoryStore) DeleteRolePermission(roleID string, permissionID string, deny bool) error {
+	if err := checkUUIDs(roleID, permissionID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	found := false
+	for id, rp := range store.rolePermissions {
+		if rp.RoledID == roleID && rp.PermissionID == permissionID && rp.Deny == deny {
+			delete(store.rolePermissions, id)
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetRolePermissions returns the permission grants of roles roleIDs
+func (store *MemoryStore) GetRolePermissions(roleIDs []string) ([]RolePermission, error) {
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(roleIDs)
+	var rolePermissions []RolePermission
+	for _, rp := range store.rolePermissions {
+		if wanted[rp.RoledID] {
+			rolePermissions = append(rolePermissions, rp)
+		}
+	}
+	return rolePermissions, nil
+}
+
+// GetRolePermissionsByPermissionIDs returns the grants and denials of permissionIDs to
+// roles
+func (store *MemoryStore) GetRolePermissionsByPermissionIDs(permissionIDs []string) ([]RolePermission, error) {
+	if err := checkUUIDs(permissionIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(permissionIDs)
+	var rolePermissions []RolePermission
+	for _, rp := range store.rolePermissions {
+		if wanted[rp.PermissionID] {
+			rolePermissions = append(rolePermissions, rp)
+		}
+	}
+	return rolePermissions, nil
+}
+
+// DeleteExpiredRolePermissions removes the role permissions that expired at
+// or before before and returns them
+func (store *MemoryStore) DeleteExpiredRolePermissions(before time.Time) ([]RolePermission, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var rolePermissions []RolePermission
+	for id, rp := range store.rolePermissions {
+		if rp.ExpiresAt != nil && !rp.ExpiresAt.After(before) {
+			rolePermissions = append(rolePermissions, rp)
+			delete(store.rolePermissions, id)
+		}
+	}
+	return rolePermissions, nil
+}
+
+// InsertEntityRole assigns a role to an entity
+func (store *MemoryStore) InsertEntityRole(entityRole EntityRole) error {
+	if err := checkUUIDs(entityRole.ID, entityRole.RoleID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.entityRoles[entityRole.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := store.roles[entityRole.RoleID]; !ok {
+		return ErrMissingReference
+	}
+	for _, er := range store.entityRoles {
+		if er.EntityID == entityRole.EntityID && er.RoleID == entityRole.RoleID && er.Resource == entityRole.Resource {
+			return ErrDuplicate
+		}
+	}
+	store.entityRoles[entityRole.ID] = entityRole
+	return nil
+}
+
+// DeleteEntityRole unassigns a role from an entity on resource, failing
+// with ErrNotFound if it was not assigned there
+func (store *MemoryStore) DeleteEntityRole(entityID string, roleID string, resource Resource) error {
+	if err := checkUUIDs(roleID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, er := range store.entityRoles {
+		if er.EntityID == entityID && er.RoleID == roleID && er.Resource == resource {
+			delete(store.entityRoles, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetEntityRoles returns the role assignments of entities entityIDs
+func (store *MemoryStore) GetEntityRoles(entityIDs []string) ([]EntityRole, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(entityIDs)
+	var entityRoles []EntityRole
+	for _, er := range store.entityRoles {
+		if wanted[er.EntityID] {
+			entityRoles = append(entityRoles, er)
+		}
+	}
+	return entityRoles, nil
+}
+
+// GetEntityRolesByRoleIDs returns the assignments of roles roleIDs
+func (store *MemoryStore) GetEntityRolesByRoleIDs(roleIDs []string) ([]EntityRole, error) {
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(roleIDs)
+	var entityRoles []EntityRole
+	for _, er := range store.entityRoles {
+		if wanted[er.RoleID] {
+			entityRoles = append(entityRoles, er)
+		}
+	}
+	return entityRoles, nil
+}
+
+// ListEntityIDsByRoleIDs returns a page of the ids of the entities assigned
+// any of roleIDs at time at, on any resource, or added directly to any of
+// groupIDs
+func (store *MemoryStore) ListEntityIDsByRoleIDs(roleIDs []string, groupIDs []string, at time.Time, query ListQuery) ([]string, error) {
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	if err := checkUUIDs(groupIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wantedRoles := stringSet(roleIDs)
+	wantedGroups := stringSet(groupIDs)
+	holders := map[string]bool{}
+	for _, er := range store.entityRoles {
+		if wantedRoles[er.RoleID] && er.activeAt(at) {
+			holders[er.EntityID] = true
+		}
+	}
+	for _, gm := range store.groupMembers {
+		if wantedGroups[gm.GroupID] {
+			holders[gm.EntityID] = true
+		}
+	}
+	var entityIDs []string
+	for entityID := range holders {
+		entityIDs = append(entityIDs, entityID)
+	}
+	return pageEntityIDs(entityIDs, query), nil
+}
+
+// DeleteExpiredEntityRoles removes the role assignments that expired at or
+// before before and returns them
+func (store *MemoryStore) DeleteExpiredEntityRoles(before time.Time) ([]EntityRole, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var entityRoles []EntityRole
+	for id, er := range store.entityRoles {
+		if er.ExpiresAt != nil && !er.ExpiresAt.After(before) {
+			entityRoles = append(entityRoles, er)
+			delete(store.entityRoles, id)
+		}
+	}
+	return entityRoles, nil
+}
+
+// InsertEntityDenial denies a permission to an entity
+func (store *MemoryStore) InsertEntityDenial(entityDenial EntityDenial) error {
+	if err := checkUUIDs(entityDenial.ID, entityDenial.PermissionID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.entityDenials[entityDenial.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := store.permissions[entityDenial.PermissionID]; !ok {
+		return ErrMissingReference
+	}
+	for _, ed := range store.entityDenials {
+		if ed.EntityID == entityDenial.EntityID && ed.PermissionID == entityDenial.PermissionID {
+			return ErrDuplicate
+		}
+	}
+	store.entityDenials[entityDenial.ID] = entityDenial
+	return nil
+}
+
+// DeleteEntityDenial removes an entity's denial of a permission, failing
+// with ErrNotFound if there is none
+func (store *MemoryStore) DeleteEntityDenial(entityID string, permissionID string) error {
+	if err := checkUUIDs(permissionID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, ed := range store.entityDenials {
+		if ed.EntityID == entityID && ed.PermissionID == permissionID {
+			delete(store.entityDenials, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetEntityDenials returns the permission denials of entities entityIDs
+func (store *MemoryStore) GetEntityDenials(entityIDs []string) ([]EntityDenial, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(entityIDs)
+	var entityDenials []EntityDenial
+	for _, ed := range store.entityDenials {
+		if wanted[ed.EntityID] {
+			entityDenials = append(entityDenials, ed)
+		}
+	}
+	return entityDenials, nil
+}
+
+// GetEntityDenialsByPermissionIDs returns the denials of permissions
+// permissionIDs
+func (store *MemoryStore) GetEntityDenialsByPermissionIDs(permissionIDs []string) ([]EntityDenial, error) {
+	if err := checkUUIDs(permissionIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(permissionIDs)
+	var entityDenials []EntityDenial
+	for _, ed := range store.entityDenials {
+		if wanted[ed.PermissionID] {
+			entityDenials = append(entityDenials, ed)
+		}
+	}
+	return entityDenials, nil
+}
+
+// InsertGroup inserts a group
+func (store *MemoryStore) InsertGroup(group Group) error {
+	if err := checkUUIDs(group.ID, group.AppID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.apps[group.AppID]; !ok {
+		return ErrMissingReference
+	}
+	if _, ok := store.groups[group.ID]; ok {
+		return ErrDuplicate
+	}
+	for _, g := range store.groups {
+		if g.AppID == group.AppID && g.Name == group.Name {
+			return ErrDuplicate
+		}
+	}
+	store.groups[group.ID] = group
+	return nil
+}
+
+// GetGroup returns a group by id
+func (store *MemoryStore) GetGroup(groupID string) (Group, error) {
+	if err := checkUUIDs(groupID); err != nil {
+		return Group{}, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	group, ok := store.groups[groupID]
+	if !ok {
+		return Group{}, ErrNotFound
+	}
+	return group, nil
+}
+
+// GetGroupsByAppID returns a list of all groups created for an app
+func (store *MemoryStore) GetGroupsByAppID(appID string) ([]Group, error) {
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	groups := []Group{}
+	for _, group := range store.groups {
+		if group.AppID == appID {
+			groups = append(groups, group)
+		}
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+// DeleteGroup deletes a group and all cascading records, failing with
+// ErrNotFound if there is none
+func (store *MemoryStore) DeleteGroup(groupID string) error {
+	if err := checkUUIDs(groupID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.groups[groupID]; !ok {
+		return ErrNotFound
+	}
+	store.deleteGroup(groupID)
+	return nil
+}
+
+func (store *MemoryStore) deleteGroup(groupID string) {
+	for id, gm := range store.groupMembers {
+		if gm.GroupID == groupID {
+			delete(store.groupMembers, id)
+		}
+	}
+	for id, gp := range store.groupParents {
+		if gp.GroupID == groupID || gp.ParentID == groupID {
+			delete(store.groupParents, id)
+		}
+	}
+	for id, gr := range store.groupRoles {
+		if gr.GroupID == groupID {
+			delete(store.groupRoles, id)
+		}
+	}
+	delete(store.groups, groupID)
+}
+
+// InsertGroupMember adds an entity to a group
+func (store *MemoryStore) InsertGroupMember(groupMember GroupMember) error {
+	if err := checkUUIDs(groupMember.ID, groupMember.GroupID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.groupMembers[groupMember.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := store.groups[groupMember.GroupID]; !ok {
+		return ErrMissingReference
+	}
+	for _, gm := range store.groupMembers {
+		if gm.GroupID == groupMember.GroupID && gm.EntityID == groupMember.EntityID {
+			return ErrDuplicate
+		}
+	}
+	store.groupMembers[groupMember.ID] = groupMember
+	return nil
+}
+
+// DeleteGroupMember removes an entity from a group, failing with
+// ErrNotFound if it is not a member
+func (store *MemoryStore) DeleteGroupMember(groupID string, entityID string) error {
+	if err := checkUUIDs(groupID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, gm := range store.groupMembers {
+		if gm.GroupID == groupID && gm.EntityID == entityID {
+			delete(store.groupMembers, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetGroupMembers returns the entities added directly to a group
+func (store *MemoryStore) GetGroupMembers(groupID string) ([]GroupMember, error) {
+	if err := checkUUIDs(groupID); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var members []GroupMember
+	for _, gm := range store.groupMembers {
+		if gm.GroupID == groupID {
+			members = append(members, gm)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].EntityID < members[j].EntityID })
+	return members, nil
+}
+
+// GetEntityGroups returns the group memberships of entities entityIDs
+func (store *MemoryStore) GetEntityGroups(entityIDs []string) ([]GroupMember, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(entityIDs)
+	var members []GroupMember
+	for _, gm := range store.groupMembers {
+		if wanted[gm.EntityID] {
+			members = append(members, gm)
+		}
+	}
+	return members, nil
+}
+
+// InsertGroupParent nests a group in a parent group
+func (store *MemoryStore) InsertGroupParent(groupParent GroupParent) error {
+	if err := checkUUIDs(groupParent.ID, groupParent.GroupID, groupParent.ParentID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.groupParents[groupParent.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := store.groups[groupParent.GroupID]; !ok {
+		return ErrMissingReference
+	}
+	if _, ok := store.groups[groupParent.ParentID]; !ok {
+		return ErrMissingReference
+	}
+	for _, gp := range store.groupParents {
+		if gp.GroupID == groupParent.GroupID && gp.ParentID == groupParent.ParentID {
+			return ErrDuplicate
+		}
+	}
+	store.groupParents[groupParent.ID] = groupParent
+	return nil
+}
+
+// DeleteGroupParent stops a group being nested in a parent group, failing
+// with ErrNotFound if it is not
+func (store *MemoryStore) DeleteGroupParent(groupID string, parentID string) error {
+	if err := checkUUIDs(groupID, parentID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, gp := range store.groupParents {
+		if gp.GroupID == groupID && gp.ParentID == parentID {
+			delete(store.groupParents, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetGroupParents returns the parent links of groups groupIDs
+func (store *MemoryStore) GetGroupParents(groupIDs []string) ([]GroupParent, error) {
+	if err := checkUUIDs(groupIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(groupIDs)
+	var groupParents []GroupParent
+	for _, gp := range store.groupParents {
+		if wanted[gp.GroupID] {
+			groupParents = append(groupParents, gp)
+		}
+	}
+	return groupParents, nil
+}
+
+// GetGroupChildren returns the links of the groups nested directly in parentIDs
+func (store *MemoryStore) GetGroupChildren(parentIDs []string) ([]GroupParent, error) {
+	if err := checkUUIDs(parentIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(parentIDs)
+	var children []GroupParent
+	for _, gp := range store.groupParents {
+		if wanted[gp.ParentID] {
+			children = append(children, gp)
+		}
+	}
+	return children, nil
+}
+
+// InsertGroupRole assigns a role to a group
+func (store *MemoryStore) InsertGroupRole(groupRole GroupRole) error {
+	if err := checkUUIDs(groupRole.ID, groupRole.GroupID, groupRole.RoleID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.groupRoles[groupRole.ID]; ok {
+		return ErrDuplicate
+	}
+	if _, ok := store.groups[groupRole.GroupID]; !ok {
+		return ErrMissingReference
+	}
+	if _, ok := store.roles[groupRole.RoleID]; !ok {
+		return ErrMissingReference
+	}
+	for _, gr := range store.groupRoles {
+		if gr.GroupID == groupRole.GroupID && gr.RoleID == groupRole.RoleID {
+			return ErrDuplicate
+		}
+	}
+	store.groupRoles[groupRole.ID] = groupRole
+	return nil
+}
+
+// DeleteGroupRole unassigns a role from a group, failing with ErrNotFound
+// if it was not assigned
+func (store *MemoryStore) DeleteGroupRole(groupID string, roleID string) error {
+	if err := checkUUIDs(groupID, roleID); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, gr := range store.groupRoles {
+		if gr.GroupID == groupID && gr.RoleID == roleID {
+			delete(store.groupRoles, id)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// GetGroupRoles returns the role assignments of groups groupIDs
+func (store *MemoryStore) GetGroupRoles(groupIDs []string) ([]GroupRole, error) {
+	if err := checkUUIDs(groupIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(groupIDs)
+	var groupRoles []GroupRole
+	for _, gr := range store.groupRoles {
+		if wanted[gr.GroupID] {
+			groupRoles = append(groupRoles, gr)
+		}
+	}
+	return groupRoles, nil
+}
+
+// GetGroupRolesByRoleIDs returns the assignments of roleIDs to groups
+func (store *MemoryStore) GetGroupRolesByRoleIDs(roleIDs []string) ([]GroupRole, error) {
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	wanted := stringSet(roleIDs)
+	var groupRoles []GroupRole
+	for _, gr := range store.groupRoles {
+		if wanted[gr.RoleID] {
+			groupRoles = append(groupRoles, gr)
+		}
+	}
+	return groupRoles, nil
+}
+
+// PutNamespace creates or replaces a namespace configuration
+func (store *MemoryStore) PutNamespace(namespace Namespace) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	relations := map[string]Rewrite{}
+	for relation, rewrite := range namespace.Relations {
+		relations[relation] = rewrite
+	}
+	namespace.Relations = relations
+	store.namespaces[namespace.Name] = namespace
+	return nil
+}
+
+// GetNamespace returns a namespace configuration by name
+func (store *MemoryStore) GetNamespace(name string) (Namespace, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	namespace, ok := store.namespaces[name]
+	if !ok {
+		return Namespace{}, ErrNotFound
+	}
+	return namespace, nil
+}
+
+// InsertRelationTuple stores a relation tuple
+func (store *MemoryStore) InsertRelationTuple(tuple RelationTuple) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.namespaces[tuple.Namespace]; !ok {
+		return ErrMissingReference
+	}
+	row := relationTupleToRow(tuple)
+	if store.relationTuples[row] {
+		return ErrDuplicate
+	}
+	store.relationTuples[row] = true
+	return nil
+}
+
+// DeleteRelationTuple removes a relation tuple, failing with ErrNotFound
+// if there is none
+func (store *MemoryStore) DeleteRelationTuple(tuple RelationTuple) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	row := relationTupleToRow(tuple)
+	if _, ok := store.relationTuples[row]; !ok {
+		return ErrNotFound
+	}
+	delete(store.relationTuples, row)
+	return nil
+}
+
+// GetRelationTuples returns the tuples relating subjects to an object by
+// relation
+func (store *MemoryStore) GetRelationTuples(namespace string, objectID string, relation string) ([]RelationTuple, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var tuples []RelationTuple
+	for row := range store.relationTuples {
+		if row.Namespace == namespace && row.ObjectID == objectID && row.Relation == relation {
+			tuples = append(tuples, row.tuple())
+		}
+	}
+	return tuples, nil
+}
+
+func sortApps(apps []App) {
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+}
+
+func sortRoles(roles []Role) {
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+}
+
+func sortPermissions(perms []Permission) {
+	sort.Slice(perms, func(i, j int) bool { return perms[i].Name < perms[j].Name })
+}
+
+func sortGroups(groups []Group) {
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+}
+
+func stringSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
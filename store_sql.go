@@ -0,0 +1,1154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+	"time"
+)
+
+// SQLStore is a Store backed by a sql database, either postgres using
+// migrate.sql or sqlite using migrate_sqlite.sql. Queries are written with
+// ? placeholders and rebound for the driver.
+type SQLStore struct {
+	DB *sqlx.DB
+	tx *sqlx.Tx
+}
+
+// sqlHandle is what SQLStore queries through, the database or the
+// transaction in progress
+type sqlHandle interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	Rebind(query string) string
+}
+
+func (store *SQLStore) handle() sqlHandle {
+	if store.tx != nil {
+		return store.tx
+	}
+	return store.DB
+}
+
+// Transact runs fn in a database transaction, committing it if fn returns
+// nil and rolling it back otherwise
+func (store *SQLStore) Transact(fn func(store Store) error) error {
+	if store.tx != nil {
+		return fn(store)
+	}
+	tx, err := store.DB.Beginx()
+	if err != nil {
+		return translateError(err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+	if err := fn(&SQLStore{DB: store.DB, tx: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return translateError(err)
+	}
+	committed = true
+	return nil
+}
+
+// NewSQLStore is a factory for SQLStore structs
+func NewSQLStore(db *sqlx.DB) *SQLStore {
+	return &SQLStore{DB: db}
+}
+
+func (store *SQLStore) exec(query string, args ...interface{}) error {
+	db := store.handle()
+	_, err := db.Exec(db.Rebind(query), args...)
+	return translateError(err)
+}
+
+// execOne runs a statement that should change a single row, failing with
+// ErrNotFound if it changes none
+func (store *SQLStore) execOne(query string, args ...interface{}) error {
+	db := store.handle()
+	result, err := db.Exec(db.Rebind(query), args...)
+	if err != nil {
+		return translateError(err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *SQLStore) get(dest interface{}, query string, args ...interface{}) error {
+	db := store.handle()
+	return translateError(db.Get(dest, db.Rebind(query), args...))
+}
+
+func (store *SQLStore) selectAll(dest interface{}, query string, args ...interface{}) error {
+	db := store.handle()
+	return translateError(db.Select(dest, db.Rebind(query), args...))
+}
+
+// selectIn is selectAll for queries with slice arguments expanded into IN
+// lists. The slices must not be empty.
+func (store *SQLStore) selectIn(dest interface{}, query string, args ...interface{}) error {
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return err
+	}
+	return store.selectAll(dest, query, args...)
+}
+
+// execIn is exec for statements with slice arguments expanded into IN lists.
+// The slices must not be empty.
+func (store *SQLStore) execIn(query string, args ...interface{}) error {
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return err
+	}
+	return store.exec(query, args...)
+}
+
+// insertChunkSize bounds the rows in one INSERT, keeping its parameters
+// under the database's limit
+const insertChunkSize = 250
+
+// namedRow is the shape roles and permissions share
+type namedRow struct {
+	ID    string `db:"id"`
+	Name  string `db:"name"`
+	AppID string `db:"app_id"`
+}
+
+// insertNamedRows inserts rows into table, roles or permissions, in one
+// transaction and returns the rows inserted. In InsertSkipExisting mode rows
+// whose name is already taken in their app, or by an earlier row, are left
+// out; a concurrent insert of the same name can still fail the call with
+// ErrDuplicate.
+func (store *SQLStore) insertNamedRows(table string, rows []namedRow, mode InsertMode) ([]namedRow, error) {
+	for _, row := range rows {
+		if err := checkUUIDs(row.ID, row.AppID); err != nil {
+			return nil, err
+		}
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	var inserted []namedRow
+	err := store.Transact(func(tx Store) error {
+		txStore := tx.(*SQLStore)
+		inserted = rows
+		if mode == InsertSkipExisting {
+			var err error
+			if inserted, err = txStore.untakenNamedRows(table, rows); err != nil {
+				return err
+			}
+		}
+		for start := 0; start < len(inserted); start += insertChunkSize {
+			end := start + insertChunkSize
+			if end > len(inserted) {
+				end = len(inserted)
+			}
+			var args []interface{}
+			for _, row := range inserted[start:end] {
+				args = append(args, row.ID, row.Name, row.AppID)
+			}
+			values := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", end-start), ", ")
+			if err := txStore.exec("INSERT INTO "+table+" (id, name, app_id) VALUES "+values+";", args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// untakenNamedRows leaves out the rows whose name is taken in their app,
+// either in table or by an earlier row
+func (store *SQLStore) untakenNamedRows(table string, rows []namedRow) ([]namedRow, error) {
+	var appIDs []string
+	for _, row := range rows {
+		appIDs = append(appIDs, row.AppID)
+	}
+	var existing []namedRow
+	err := store.selectIn(&existing, "SELECT id, name, app_id FROM "+table+" WHERE app_id IN (?);", appIDs)
+	if err != nil {
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for _, row := range existing {
+		taken[row.AppID+"\x00"+row.Name] = true
+	}
+	var untaken []namedRow
+	for _, row := range rows {
+		if !taken[row.AppID+"\x00"+row.Name] {
+			taken[row.AppID+"\x00"+row.Name] = true
+			untaken = append(untaken, row)
+		}
+	}
+	return untaken, nil
+}
+
+// translateError maps driver errors onto the store errors so callers can
+// tell them apart without knowing the backend
+func translateError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	switch e := err.(type) {
+	case *pq.Error:
+		switch string(e.Code) {
+		case "23505":
+			return ErrDuplicate
+		case "23503":
+			return ErrMissingReference
+		}
+	case sqlite3.Error:
+		switch e.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return ErrDuplicate
+		case sqlite3.ErrConstraintForeignKey:
+			return ErrMissingReference
+		}
+	}
+	return err
+}
+
+// InsertApp inserts an app
+func (store *SQLStore) InsertApp(app App) error {
+	if err := checkUUIDs(app.ID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO apps (id, name) VALUES (
+		?, ?
+	);
+	`, app.ID, app.Name)
+}
+
+// GetApp returns an app by id
+func (store *SQLStore) GetApp(appID string) (App, error) {
+	var app App
+	if err := checkUUIDs(appID); err != nil {
+		return app, err
+	}
+	err := store.get(&app, `
+	SELECT id, name
+	FROM apps
+	WHERE id = ?;
+	`, appID)
+	return app, err
+}
+
+// GetAppByName returns an app by name
+func (store *SQLStore) GetAppByName(name string) (App, error) {
+	var app App
+	err := store.get(&app, `
+	SELECT id, name
+	FROM apps
+	WHERE name = ?;
+	`, name)
+	return app, err
+}
+
+// GetApps returns a list of all apps
+func (store *SQLStore) GetApps() ([]App, error) {
+	var apps []App
+	err := store.selectAll(&apps, `SELECT id, name FROM apps;`)
+	return apps, err
+}
+
+// ListApps returns a page of apps
+func (store *SQLStore) ListApps(query ListQuery) ([]App, error) {
+	apps := []App{}
+	clauses, args := query.sql(nil, nil)
+	err := store.selectAll(&apps, `
+	SELECT id, name
+	FROM apps
+	`+clauses+`;`, args...)
+	return apps, err
+}
+
+// GetAppsByEntityID returns a list of all apps an entity has a role in
+func (store *SQLStore) GetAppsByEntityID(entityID string) ([]App, error) {
+	var apps []App
+	err := store.selectAll(&apps, `
+	SELECT DISTINCT a.id, a.name
+	FROM apps AS a
+	INNER JOIN entity_roles AS er
+		ON er.entity_id = ?
+	INNER JOIN roles AS r
+		ON r.app_id = a.id
+			AND r.id = er.role_id;
+	`, entityID)
+	return apps, err
+}
+
+// UpdateApp renames an app
+func (store *SQLStore) UpdateApp(app App) error {
+	if err := checkUUIDs(app.ID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	UPDATE apps SET name = ? WHERE id = ?;
+	`, app.Name, app.ID)
+}
+
+// DeleteApp deletes an app and all cascading records, failing with
+// ErrNotFound if there is none
+func (store *SQLStore) DeleteApp(appID string) error {
+	if err := checkUUIDs(appID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM apps WHERE id = ?;
+	`, appID)
+}
+
+// InsertRoles inserts roles, either all of them or none, and returns the
+// ones inserted. In InsertSkipExisting mode roles whose name is taken are
+// left out instead.
+func (store *SQLStore) InsertRoles(roles []Role, mode InsertMode) ([]Role, error) {
+	var rows []namedRow
+	for _, role := range roles {
+		rows = append(rows, namedRow(role))
+	}
+	rows, err := store.insertNamedRows("roles", rows, mode)
+	var inserted []Role
+	for _, row := range rows {
+		inserted = append(inserted, Role(row))
+	}
+	return inserted, err
+}
+
+// GetRole returns a role by id
+func (store *SQLStore) GetRole(roleID string) (Role, error) {
+	var role Role
+	if err := checkUUIDs(roleID); err != nil {
+		return role, err
+	}
+	err := store.get(&role, `
+	SELECT id, name, app_id
+	FROM roles
+	WHERE id = ?;
+	`, roleID)
+	return role, err
+}
+
+// GetRoles returns the roles with ids roleIDs, skipping any that do not
+// exist
+func (store *SQLStore) GetRoles(roleIDs []string) ([]Role, error) {
+	roles := []Role{}
+	if len(roleIDs) == 0 {
+		return roles, nil
+	}
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&roles, `
+	SELECT id, name, app_id
+	FROM roles
+	WHERE id IN (?);
+	`, roleIDs)
+	return roles, err
+}
+
+// GetRolesByAppID returns a list of all roles created for an app
+func (store *SQLStore) GetRolesByAppID(appID string) ([]Role, error) {
+	roles := []Role{}
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	err := store.selectAll(&roles, `
+	SELECT id, name, app_id
+	FROM roles
+	WHERE app_id = ?;
+	`, appID)
+	return roles, err
+}
+
+// ListRolesByAppID returns a page of the roles created for an app
+func (store *SQLStore) ListRolesByAppID(appID string, query ListQuery) ([]Role, error) {
+	roles := []Role{}
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	clauses, args := query.sql([]string{"app_id = ?"}, []interface{}{appID})
+	err := store.selectAll(&roles, `
+	SELECT id, name, app_id
+	FROM roles
+	`+clauses+`;`, args...)
+	return roles, err
+}
+
+// GetRolesByEntityID returns roles by entity_id, whatever resource they
+// are assigned on
+func (store *SQLStore) GetRolesByEntityID(entityID string) ([]Role, error) {
+	var roles []Role
+	err := store.selectAll(&roles, `
+	SELECT DISTINCT r.id, r.name, r.app_id
+	FROM roles AS r
+	INNER JOIN entity_roles AS er
+		ON r.id = er.role_id
+			AND er.entity_id = ?;
+	`, entityID)
+	return roles, err
+}
+
+// UpdateRole renames a role. A role cannot move to another app.
+func (store *SQLStore) UpdateRole(role Role) error {
+	if err := checkUUIDs(role.ID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	UPDATE roles SET name = ? WHERE id = ?;
+	`, role.Name, role.ID)
+}
+
+// DeleteRole deletes a role and all cascading records, failing with
+// ErrNotFound if there is none
+func (store *SQLStore) DeleteRole(roleID string) error {
+	if err := checkUUIDs(roleID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM roles WHERE id = ?;
+	`, roleID)
+}
+
+// InsertRoleParent makes a role inherit from a parent role
+func (store *SQLStore) InsertRoleParent(roleParent RoleParent) error {
+	if err := checkUUIDs(roleParent.ID, roleParent.RoleID, roleParent.ParentID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO role_parents (id, role_id, parent_id) VALUES (
+		?, ?, ?
+	);
+	`, roleParent.ID, roleParent.RoleID, roleParent.ParentID)
+}
+
+// DeleteRoleParent stops a role inheriting from a parent role, failing
+// with ErrNotFound if it does not
+func (store *SQLStore) DeleteRoleParent(roleID string, parentID string) error {
+	if err := checkUUIDs(roleID, parentID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM role_parents
+	WHERE role_id = ?
+	AND parent_id = ?;
+	`, roleID, parentID)
+}
+
+// GetRoleParents returns the parent links of roles roleIDs
+func (store *SQLStore) GetRoleParents(roleIDs []string) ([]RoleParent, error) {
+	var parents []RoleParent
+	if len(roleIDs) == 0 {
+		return parents, nil
+	}
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&parents, `
+	SELECT id, role_id, parent_id
+	FROM role_parents
+	WHERE role_id IN (?);
+	`, roleIDs)
+	return parents, err
+}
+
+// GetRoleChildren returns the links of the roles that inherit directly from parentIDs
+func (store *SQLStore) GetRoleChildren(parentIDs []string) ([]RoleParent, error) {
+	var parents []RoleParent
+	if len(parentIDs) == 0 {
+		return parents, nil
+	}
+	if err := checkUUIDs(parentIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&parents, `
+	SELECT id, role_id, parent_id
+	FROM role_parents
+	WHERE parent_id IN (?);
+	`, parentIDs)
+	return parents, err
+}
+
+// InsertPermissions inserts permissions, either all of them or none, and
+// returns the ones inserted. In InsertSkipExisting mode permissions whose
+// name is taken are left out instead.
+func (store *SQLStore) InsertPermissions(perms []Permission, mode InsertMode) ([]Permission, error) {
+	var rows []namedRow
+	for _, perm := range perms {
+		rows = append(rows, namedRow(perm))
+	}
+	rows, err := store.insertNamedRows("permissions", rows, mode)
+	var inserted []Permission
+	for _, row := range rows {
+		inserted = append(inserted, Permission(row))
+	}
+	return inserted, err
+}
+
+// GetPermissionByName returns a permission by its name within an app
+func (store *SQLStore) GetPermissionByName(appID string, name string) (Permission, error) {
+	var perm Permission
+	if err := checkUUIDs(appID); err != nil {
+		return perm, err
+	}
+	err := store.get(&perm, `
+	SELECT id, name, app_id
+	FROM permissions
+	WHERE app_id = ?
+	AND name = ?;
+	`, appID, name)
+	return perm, err
+}
+
+// GetPermissions returns the permissions with ids permissionIDs, skipping
+// any that do not exist
+func (store *SQLStore) GetPermissions(permissionIDs []string) ([]Permission, error) {
+	var perms []Permission
+	if len(permissionIDs) == 0 {
+		return perms, nil
+	}
+	if err := checkUUIDs(permissionIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&perms, `
+	SELECT id, name, app_id
+	FROM permissions
+	WHERE id IN (?);
+	`, permissionIDs)
+	return perms, err
+}
+
+// GetPermissionsByAppID returns a list of all permissions created for an app
+func (store *SQLStore) GetPermissionsByAppID(appID string) ([]Permission, error) {
+	perms := []Permission{}
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	err := store.selectAll(&perms, `
+	SELECT id, name, app_id
+	FROM permissions
+	WHERE app_id = ?;
+	`, appID)
+	return perms, err
+}
+
+// GetPermissionsByRoleID returns a list of all permissions granted to, or
+// with deny set denied to, a role
+func (store *SQLStore) GetPermissionsByRoleID(roleID string, deny bool) ([]Permission, error) {
+	var perms []Permission
+	if err := checkUUIDs(roleID); err != nil {
+		return nil, err
+	}
+	err := store.selectAll(&perms, `
+	SELECT p.id, p.name, p.app_id
+	FROM permissions AS p
+	INNER JOIN role_permissions AS rp
+		ON p.id = rp.permission_id
+			AND rp.role_id = ?
+			AND rp.deny = ?;
+	`, roleID, deny)
+	return perms, err
+}
+
+// UpdatePermission renames a permission. A permission cannot move to
+// another app.
+func (store *SQLStore) UpdatePermission(perm Permission) error {
+	if err := checkUUIDs(perm.ID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	UPDATE permissions SET name = ? WHERE id = ?;
+	`, perm.Name, perm.ID)
+}
+
+// DeletePermission deletes a permission and all cascading records, failing
+// with ErrNotFound if there is none
+func (store *SQLStore) DeletePermission(permissionID string) error {
+	if err := checkUUIDs(permissionID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM permissions WHERE id = ?;
+	`, permissionID)
+}
+
+// InsertRolePermission grants or denies a permission to a role
+func (store *SQLStore) InsertRolePermission(rolePermission RolePermission) error {
+	if err := checkUUIDs(rolePermission.ID, rolePermission.RoledID, rolePermission.PermissionID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO role_permissions (id, role_id, permission_id, deny, condition, not_before, expires_at) VALUES (
+		?, ?, ?, ?, ?, ?, ?
+	);
+	`, rolePermission.ID, rolePermission.RoledID, rolePermission.PermissionID, rolePermission.Deny,
+		rolePermission.Condition, rolePermission.NotBefore, rolePermission.ExpiresAt)
+}
+
+// DeleteRolePermission removes a role's grant of, or with deny set its
+// denial of, a permission, failing with ErrNotFound if there is none
+func (store *SQLStore) DeleteRolePermission(roleID string, permissionID string, deny bool) error {
+	if err := checkUUIDs(roleID, permissionID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM role_permissions
+	WHERE role_id = ?
+	AND permission_id = ?
+	AND deny = ?;
+	`, roleID, permissionID, deny)
+}
+
+// GetRolePermissions returns the permission grants of roles roleIDs
+func (store *SQLStore) GetRolePermissions(roleIDs []string) ([]RolePermission, error) {
+	var rolePermissions []RolePermission
+	if len(roleIDs) == 0 {
+		return rolePermissions, nil
+	}
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&rolePermissions, `
+	SELECT id, role_id, permission_id, deny, condition, not_before, expires_at
+	FROM role_permissions
+	WHERE role_id IN (?);
+	`, roleIDs)
+	return rolePermissions, err
+}
+
+// GetRolePermissionsByPermissionIDs returns the grants and denials of permissionIDs to
+// roles
+func (store *SQLStore) GetRolePermissionsByPermissionIDs(permissionIDs []string) ([]RolePermission, error) {
+	var rolePermissions []RolePermission
+	if len(permissionIDs) == 0 {
+		return rolePermissions, nil
+	}
+	if err := checkUUIDs(permissionIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&rolePermissions, `
+	SELECT id, role_id, permission_id, deny, condition, not_before, expires_at
+	FROM role_permissions
+	WHERE permission_id IN (?);
+	`, permissionIDs)
+	return rolePermissions, err
+}
+
+// DeleteExpiredRolePermissions removes the role permissions that expired at
+// or before before and returns them
+func (store *SQLStore) DeleteExpiredRolePermissions(before time.Time) ([]RolePermission, error) {
+	var rolePermissions []RolePermission
+	err := store.selectAll(&rolePermissions, `
+	SELECT id, role_id, permission_id, deny, condition, not_before, expires_at
+	FROM role_permissions
+	WHERE expires_at <= ?;
+	`, before)
+	if err != nil || len(rolePermissions) == 0 {
+		return rolePermissions, err
+	}
+	var ids []string
+	for _, rp := range rolePermissions {
+		ids = append(ids, rp.ID)
+	}
+	return rolePermissions, store.execIn(`
+	DELETE FROM role_permissions WHERE id IN (?);
+	`, ids)
+}
+
+// InsertEntityRole assigns a role to an entity
+func (store *SQLStore) InsertEntityRole(entityRole EntityRole) error {
+	if err := checkUUIDs(entityRole.ID, entityRole.RoleID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO entity_roles (id, entity_id, role_id, resource_type, resource_id, not_before, expires_at) VALUES (
+		?, ?, ?, ?, ?, ?, ?
+	);
+	`, entityRole.ID, entityRole.EntityID, entityRole.RoleID, entityRole.Resource.Type, entityRole.Resource.ID,
+		entityRole.NotBefore, entityRole.ExpiresAt)
+}
+
+// DeleteEntityRole unassigns a role from an entity on resource, failing
+// with ErrNotFound if it was not assigned there
+func (store *SQLStore) DeleteEntityRole(entityID string, roleID string, resource Resource) error {
+	if err := checkUUIDs(roleID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM entity_roles
+	WHERE entity_id = ?
+	AND role_id = ?
+	AND resource_type = ?
+	AND resource_id = ?;
+	`, entityID, roleID, resource.Type, resource.ID)
+}
+
+// GetEntityRoles returns the role assignments of entities entityIDs
+func (store *SQLStore) GetEntityRoles(entityIDs []string) ([]EntityRole, error) {
+	var entityRoles []EntityRole
+	if len(entityIDs) == 0 {
+		return entityRoles, nil
+	}
+	err := store.selectIn(&entityRoles, `
+	SELECT id, entity_id, role_id, resource_type, resource_id, not_before, expires_at
+	FROM entity_roles
+	WHERE entity_id IN (?);
+	`, entityIDs)
+	return entityRoles, err
+}
+
+// GetEntityRolesByRoleIDs returns the assignments of roles roleIDs
+func (store *SQLStore) GetEntityRolesByRoleIDs(roleIDs []string) ([]EntityRole, error) {
+	var entityRoles []EntityRole
+	if len(roleIDs) == 0 {
+		return entityRoles, nil
+	}
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&entityRoles, `
+	SELECT id, entity_id, role_id, resource_type, resource_id, not_before, expires_at
+	FROM entity_roles
+	WHERE role_id IN (?);
+	`, roleIDs)
+	return entityRoles, err
+}
+
+// ListEntityIDsByRoleIDs returns a page of the ids of the entities assigned
+// any of roleIDs at time at, on any resource, or added directly to any of
+// groupIDs
+func (store *SQLStore) ListEntityIDsByRoleIDs(roleIDs []string, groupIDs []string, at time.Time, query ListQuery) ([]string, error) {
+	entityIDs := []string{}
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	if err := checkUUIDs(groupIDs...); err != nil {
+		return nil, err
+	}
+	var holders []string
+	var args []interface{}
+	if len(roleIDs) > 0 {
+		holders = append(holders, `
+		SELECT entity_id AS id, entity_id AS name
+		FROM entity_roles
+		WHERE role_id IN (?)
+		AND (not_before IS NULL OR not_before <= ?)
+		AND (expires_at IS NULL OR expires_at > ?)`)
+		args = append(args, roleIDs, at.UTC(), at.UTC())
+	}
+	if len(groupIDs) > 0 {
+		holders = append(holders, `
+		SELECT entity_id AS id, entity_id AS name
+		FROM group_members
+		WHERE group_id IN (?)`)
+		args = append(args, groupIDs)
+	}
+	if len(holders) == 0 {
+		return entityIDs, nil
+	}
+	clauses, args := query.sql(nil, args)
+	err := store.selectIn(&entityIDs, `
+	SELECT id
+	FROM (`+strings.Join(holders, "\n\t\tUNION")+`
+	) AS holders
+	`+clauses+`;`, args...)
+	return entityIDs, err
+}
+
+// DeleteExpiredEntityRoles removes the role assignments that expired at or
+// before before and returns them
+func (store *SQLStore) DeleteExpiredEntityRoles(before time.Time) ([]EntityRole, error) {
+	var entityRoles []EntityRole
+	err := store.selectAll(&entityRoles, `
+	SELECT id, entity_id, role_id, resource_type, resource_id, not_before, expires_at
+	FROM entity_roles
+	WHERE expires_at <= ?;
+	`, before)
+	if err != nil || len(entityRoles) == 0 {
+		return entityRoles, err
+	}
+	var ids []string
+	for _, er := range entityRoles {
+		ids = append(ids, er.ID)
+	}
+	return entityRoles, store.execIn(`
+	DELETE FROM entity_roles WHERE id IN (?);
+	`, ids)
+}
+
+// InsertEntityDenial denies a permission to an entity
+func (store *SQLStore) InsertEntityDenial(entityDenial EntityDenial) error {
+	if err := checkUUIDs(entityDenial.ID, entityDenial.PermissionID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO entity_denials (id, entity_id, permission_id) VALUES (
+		?, ?, ?
+	);
+	`, entityDenial.ID, entityDenial.EntityID, entityDenial.PermissionID)
+}
+
+// DeleteEntityDenial removes an entity's denial of a permission, failing
+// with ErrNotFound if there is none
+func (store *SQLStore) DeleteEntityDenial(entityID string, permissionID string) error {
+	if err := checkUUIDs(permissionID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM entity_denials
+	WHERE entity_id = ?
+	AND permission_id = ?;
+	`, entityID, permissionID)
+}
+
+// GetEntityDenials returns the permission denials of entities entityIDs
+func (store *SQLStore) GetEntityDenials(entityIDs []string) ([]EntityDenial, error) {
+	var entityDenials []EntityDenial
+	if len(entityIDs) == 0 {
+		return entityDenials, nil
+	}
+	err := store.selectIn(&entityDenials, `
+	SELECT id, entity_id, permission_id
+	FROM entity_denials
+	WHERE entity_id IN (?);
+	`, entityIDs)
+	return entityDenials, err
+}
+
+// GetEntityDenialsByPermissionIDs returns the denials of permissions
+// permissionIDs
+func (store *SQLStore) GetEntityDenialsByPermissionIDs(permissionIDs []string) ([]EntityDenial, error) {
+	var entityDenials []EntityDenial
+	if len(permissionIDs) == 0 {
+		return entityDenials, nil
+	}
+	if err := checkUUIDs(permissionIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&entityDenials, `
+	SELECT id, entity_id, permission_id
+	FROM entity_denials
+	WHERE permission_id IN (?);
+	`, permissionIDs)
+	return entityDenials, err
+}
+
+// InsertGroup inserts a group
+func (store *SQLStore) InsertGroup(group Group) error {
+	if err := checkUUIDs(group.ID, group.AppID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO entity_groups (id, name, app_id) VALUES (
+		?, ?, ?
+	);
+	`, group.ID, group.Name, group.AppID)
+}
+
+// GetGroup returns a group by id
+func (store *SQLStore) GetGroup(groupID string) (Group, error) {
+	var group Group
+	if err := checkUUIDs(groupID); err != nil {
+		return group, err
+	}
+	err := store.get(&group, `
+	SELECT id, name, app_id
+	FROM entity_groups
+	WHERE id = ?;
+	`, groupID)
+	return group, err
+}
+
+// GetGroupsByAppID returns a list of all groups created for an app
+func (store *SQLStore) GetGroupsByAppID(appID string) ([]Group, error) {
+	groups := []Group{}
+	if err := checkUUIDs(appID); err != nil {
+		return nil, err
+	}
+	err := store.selectAll(&groups, `
+	SELECT id, name, app_id
+	FROM entity_groups
+	WHERE app_id = ?
+	ORDER BY name;
+	`, appID)
+	return groups, err
+}
+
+// DeleteGroup deletes a group and all cascading records, failing with
+// ErrNotFound if there is none
+func (store *SQLStore) DeleteGroup(groupID string) error {
+	if err := checkUUIDs(groupID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM entity_groups WHERE id = ?;
+	`, groupID)
+}
+
+// InsertGroupMember adds an entity to a group
+func (store *SQLStore) InsertGroupMember(groupMember GroupMember) error {
+	if err := checkUUIDs(groupMember.ID, groupMember.GroupID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO group_members (id, group_id, entity_id) VALUES (
+		?, ?, ?
+	);
+	`, groupMember.ID, groupMember.GroupID, groupMember.EntityID)
+}
+
+// DeleteGroupMember removes an entity from a group, failing with
+// ErrNotFound if it is not a member
+func (store *SQLStore) DeleteGroupMember(groupID string, entityID string) error {
+	if err := checkUUIDs(groupID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM group_members
+	WHERE group_id = ?
+	AND entity_id = ?;
+	`, groupID, entityID)
+}
+
+// GetGroupMembers returns the entities added directly to a group
+func (store *SQLStore) GetGroupMembers(groupID string) ([]GroupMember, error) {
+	var members []GroupMember
+	if err := checkUUIDs(groupID); err != nil {
+		return nil, err
+	}
+	err := store.selectAll(&members, `
+	SELECT id, group_id, entity_id
+	FROM group_members
+	WHERE group_id = ?
+	ORDER BY entity_id;
+	`, groupID)
+	return members, err
+}
+
+// GetEntityGroups returns the group memberships of entities entityIDs
+func (store *SQLStore) GetEntityGroups(entityIDs []string) ([]GroupMember, error) {
+	var members []GroupMember
+	if len(entityIDs) == 0 {
+		return members, nil
+	}
+	err := store.selectIn(&members, `
+	SELECT id, group_id, entity_id
+	FROM group_members
+	WHERE entity_id IN (?);
+	`, entityIDs)
+	return members, err
+}
+
+// InsertGroupParent nests a group in a parent group
+func (store *SQLStore) InsertGroupParent(groupParent GroupParent) error {
+	if err := checkUUIDs(groupParent.ID, groupParent.GroupID, groupParent.ParentID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO group_parents (id, group_id, parent_id) VALUES (
+		?, ?, ?
+	);
+	`, groupParent.ID, groupParent.GroupID, groupParent.ParentID)
+}
+
+// DeleteGroupParent stops a group being nested in a parent group, failing
+// with ErrNotFound if it is not
+func (store *SQLStore) DeleteGroupParent(groupID string, parentID string) error {
+	if err := checkUUIDs(groupID, parentID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM group_parents
+	WHERE group_id = ?
+	AND parent_id = ?;
+	`, groupID, parentID)
+}
+
+// GetGroupParents returns the parent links of groups groupIDs
+func (store *SQLStore) GetGroupParents(groupIDs []string) ([]GroupParent, error) {
+	var parents []GroupParent
+	if len(groupIDs) == 0 {
+		return parents, nil
+	}
+	if err := checkUUIDs(groupIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&parents, `
+	SELECT id, group_id, parent_id
+	FROM group_parents
+	WHERE group_id IN (?);
+	`, groupIDs)
+	return parents, err
+}
+
+// GetGroupChildren returns the links of the groups nested directly in parentIDs
+func (store *SQLStore) GetGroupChildren(parentIDs []string) ([]GroupParent, error) {
+	var children []GroupParent
+	if len(parentIDs) == 0 {
+		return children, nil
+	}
+	if err := checkUUIDs(parentIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&children, `
+	SELECT id, group_id, parent_id
+	FROM group_parents
+	WHERE parent_id IN (?);
+	`, parentIDs)
+	return children, err
+}
+
+// InsertGroupRole assigns a role to a group
+func (store *SQLStore) InsertGroupRole(groupRole GroupRole) error {
+	if err := checkUUIDs(groupRole.ID, groupRole.GroupID, groupRole.RoleID); err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO group_roles (id, group_id, role_id) VALUES (
+		?, ?, ?
+	);
+	`, groupRole.ID, groupRole.GroupID, groupRole.RoleID)
+}
+
+// DeleteGroupRole unassigns a role from a group, failing with ErrNotFound
+// if it was not assigned
+func (store *SQLStore) DeleteGroupRole(groupID string, roleID string) error {
+	if err := checkUUIDs(groupID, roleID); err != nil {
+		return err
+	}
+	return store.execOne(`
+	DELETE FROM group_roles
+	WHERE group_id = ?
+	AND role_id = ?;
+	`, groupID, roleID)
+}
+
+// GetGroupRoles returns the role assignments of groups groupIDs
+func (store *SQLStore) GetGroupRoles(groupIDs []string) ([]GroupRole, error) {
+	var groupRoles []GroupRole
+	if len(groupIDs) == 0 {
+		return groupRoles, nil
+	}
+	if err := checkUUIDs(groupIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&groupRoles, `
+	SELECT id, group_id, role_id
+	FROM group_roles
+	WHERE group_id IN (?);
+	`, groupIDs)
+	return groupRoles, err
+}
+
+// GetGroupRolesByRoleIDs returns the assignments of roleIDs to groups
+func (store *SQLStore) GetGroupRolesByRoleIDs(roleIDs []string) ([]GroupRole, error) {
+	var groupRoles []GroupRole
+	if len(roleIDs) == 0 {
+		return groupRoles, nil
+	}
+	if err := checkUUIDs(roleIDs...); err != nil {
+		return nil, err
+	}
+	err := store.selectIn(&groupRoles, `
+	SELECT id, group_id, role_id
+	FROM group_roles
+	WHERE role_id IN (?);
+	`, roleIDs)
+	return groupRoles, err
+}
+
+// PutNamespace creates or replaces a namespace configuration, stored as JSON
+func (store *SQLStore) PutNamespace(namespace Namespace) error {
+	config, err := json.Marshal(namespace.Relations)
+	if err != nil {
+		return err
+	}
+	return store.exec(`
+	INSERT INTO relation_namespaces (name, config) VALUES (
+		?, ?
+	)
+	ON CONFLICT (name) DO UPDATE SET config = excluded.config;
+	`, namespace.Name, string(config))
+}
+
+// GetNamespace returns a namespace configuration by name
+func (store *SQLStore) GetNamespace(name string) (Namespace, error) {
+	var config string
+	err := store.get(&config, `
+	SELECT config
+	FROM relation_namespaces
+	WHERE name = ?;
+	`, name)
+	if err != nil {
+		return Namespace{}, err
+	}
+	namespace := Namespace{Name: name}
+	err = json.Unmarshal([]byte(config), &namespace.Relations)
+	return namespace, err
+}
+
+// InsertRelationTuple stores a relation tuple
+func (store *SQLStore) InsertRelationTuple(tuple RelationTuple) error {
+	row := relationTupleToRow(tuple)
+	return store.exec(`
+	INSERT INTO relation_tuples (namespace, object_id, relation, subject_id, subject_namespace, subject_object_id, subject_relation) VALUES (
+		?, ?, ?, ?, ?, ?, ?
+	);
+	`, row.Namespace, row.ObjectID, row.Relation, row.SubjectID, row.SubjectNamespace, row.SubjectObjectID, row.SubjectRelation)
+}
+
+// DeleteRelationTuple removes a relation tuple, failing with ErrNotFound
+// if there is none
+func (store *SQLStore) DeleteRelationTuple(tuple RelationTuple) error {
+	row := relationTupleToRow(tuple)
+	return store.execOne(`
+	DELETE FROM relation_tuples
+	WHERE namespace = ?
+	AND object_id = ?
+	AND relation = ?
+	AND subject_id = ?
+	AND subject_namespace = ?
+	AND subject_object_id = ?
+	AND subject_relation = ?;
+	`, row.Namespace, row.ObjectID, row.Relation, row.SubjectID, row.SubjectNamespace, row.SubjectObjectID, row.SubjectRelation)
+}
+
+// GetRelationTuples returns the tuples relating subjects to an object by
+// relation
+func (store *SQLStore) GetRelationTuples(namespace string, objectID string, relation string) ([]RelationTuple, error) {
+	var rows []relationTupleRow
+	err := store.selectAll(&rows, `
+	SELECT namespace, object_id, relation, subject_id, subject_namespace, subject_object_id, subject_relation
+	FROM relation_tuples
+	WHERE namespace = ?
+	AND object_id = ?
+	AND relation = ?;
+	`, namespace, objectID, relation)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []RelationTuple
+	for _, row := range rows {
+		tuples = append(tuples, row.tuple())
+	}
+	return tuples, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "strings"
+
+// Permission names may be structured into segments separated by
+// PermissionSeparator, such as documents:read. A segment of
+// PermissionWildcard matches any single segment, and as the last segment it
+// matches one or more, so granting documents:* covers documents:read,
+// documents:write and any documents permission created later.
+const (
+	PermissionSeparator = ":"
+	PermissionWildcard  = "*"
+)
+
+// permissionNameMatches checks if the permission named pattern covers the
+// permission named name
+func permissionNameMatches(pattern string, name string) bool {
+	if pattern == name {
+		return true
+	}
+	patternSegments := strings.Split(pattern, PermissionSeparator)
+	nameSegments := strings.Split(name, PermissionSeparator)
+	for i, segment := range patternSegments {
+		if i >= len(nameSegments) {
+			return false
+		}
+		if segment == PermissionWildcard {
+			if i == len(patternSegments)-1 {
+				return true
+			}
+			continue
+		}
+		if segment != nameSegments[i] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(nameSegments)
+}